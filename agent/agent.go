@@ -1,6 +1,11 @@
 package agent
 
 import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
 	"github.com/enriquebris/goagent/cmd"
 	botio "github.com/enriquebris/goagent/io"
 	"github.com/enriquebris/goworkerpool"
@@ -12,16 +17,61 @@ import (
 const (
 	// default number of concurrent workers to handle the requests
 	defaultMaxConcurrentRequests = 10
+	// default time to process the enqueued entries once the agent was asked to stop listening
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 type Agent struct {
-	cmdManager *cmd.CMDManager
-	input      botio.Input
-	outputs    []botio.Output
-	inputChann chan botio.InputEntry
-	log        *logging.Logger
-	workerpool *goworkerpool.Pool
+	cmdManager  *cmd.CMDManager
+	inputs      []*agentInput
+	outputs     []botio.Output
+	router      *botio.Router
+	log         *logging.Logger
+	workerpool  *goworkerpool.Pool
 	isListening bool
+
+	// mutex to protect the listening state and the enqueued entries
+	mutex sync.Mutex
+	// max time to process the enqueued entries after the listener was cancelled (ListenContext)
+	shutdownTimeout time.Duration
+	// cancels the current listener
+	cancelListen context.CancelFunc
+	// closed once the current listener is done
+	listenDone chan struct{}
+	// drain deadline provided by Shutdown(ctx)
+	drainCtx context.Context
+	// enqueued entries not yet picked by a worker
+	pendingEntries map[uint64]botio.InputEntry
+	lastEntryID    uint64
+	// whether the enqueued entries must be dropped (the drain deadline was reached)
+	dropEntries bool
+	// entries being processed
+	totalInFlight int
+	// entries picked by a worker
+	totalProcessed int
+	// context for the handlers, it gets cancelled once the drain deadline is reached
+	handlersCtx    context.Context
+	cancelHandlers context.CancelFunc
+	// summary from the last time the agent stopped listening
+	shutdownSummary ShutdownSummary
+	// function to be invoked each time an input fails
+	inputErrorHandler InputErrorHandler
+	// channel to receive the errors from the CMDManager
+	errorChan chan error
+	// error stream's subscribers
+	errorSubscribers errorSubscribers
+	// closed to make the inputs' forwarders stop
+	stopForwarding chan struct{}
+	// inputs' forwarders
+	forwarders sync.WaitGroup
+	// rate limits applied before enqueueing the entries
+	rateLimits rateLimits
+}
+
+// enqueuedEntry is the job sent to the workerpool
+type enqueuedEntry struct {
+	id    uint64
+	entry botio.InputEntry
 }
 
 func NewAgent(log *logging.Logger, maxConcurrentRequests int) *Agent {
@@ -32,9 +82,17 @@ func NewAgent(log *logging.Logger, maxConcurrentRequests int) *Agent {
 }
 
 func (st *Agent) initialize(log *logging.Logger, maxConcurrentRequests int) {
-	st.cmdManager = cmd.NewCMDManager(nil)
-	st.inputChann = make(chan botio.InputEntry, 500)
+	st.log = log
+	st.errorChan = make(chan error, errorChannelCapacity)
+	st.cmdManager = cmd.NewCMDManager(st.errorChan)
+	go st.dispatchErrors()
+	st.inputs = make([]*agentInput, 0)
 	st.outputs = make([]botio.Output, 0)
+	// replies go back only to the entry's origin by default
+	st.router = botio.NewRouter(botio.ReplyToOriginRule())
+	st.shutdownTimeout = defaultShutdownTimeout
+	st.pendingEntries = make(map[uint64]botio.InputEntry)
+	st.rateLimits.cmds = make(map[string]*rateLimiter)
 	// goworkerpool
 	st.initializeWorkerPool(maxConcurrentRequests)
 }
@@ -47,43 +105,180 @@ func (st *Agent) initializeWorkerPool(totalWorkers int) {
 	st.workerpool = goworkerpool.NewPool(totalWorkers, 1000, false)
 
 	// set the main handler function
-	st.workerpool.SetWorkerFunc(func (data interface{}) bool {
-		// cast the job as a io.InputEntry
-		if entry, ok := data.(botio.InputEntry); !ok {
-			st.log.Errorf("Enqueued job is not a io.InputEntry: '%v'", data)
-		} else {
-			// process the entry
-			if err := st.cmdManager.Process(entry, st.outputs); err != nil {
-				st.log.Errorf("st.cmdManager.Process: '%v'", err.Error())
+	st.workerpool.SetWorkerFunc(func(data interface{}) bool {
+		// cast the job as an enqueuedEntry
+		job, ok := data.(enqueuedEntry)
+		if !ok {
+			st.log.Errorf("Enqueued job is not an enqueuedEntry: '%v'", data)
+			return true
+		}
+
+		if !st.startProcessing(job.id) {
+			// the drain deadline was reached, the entry was dropped
+			return true
+		}
+		defer st.endProcessing()
+
+		// handlers' panics are recovered by the CMDManager, this keeps the worker alive for any other panic
+		defer func() {
+			if r := recover(); r != nil {
+				st.log.Errorf("Panic while processing '%v': %v\n%s", job.entry.Query, r, debug.Stack())
 			}
+		}()
+
+		// process the entry, replies will be sent only to the routed outputs
+		if err := st.cmdManager.ProcessContext(st.getHandlersContext(), job.entry, st.router.Route(job.entry, st.outputs)); err != nil {
+			st.log.Errorf("st.cmdManager.Process: '%v'", err.Error())
 		}
 
 		return true
 	})
 }
 
-// SetInput sets the Input
-func (st *Agent) SetInput(input botio.Input) {
-	st.input = input
-}
-
 // AddOutput adds a io.Output
 func (st *Agent) AddOutput(output botio.Output) {
 	st.outputs = append(st.outputs, output)
 }
 
+// SetRoutingRules replaces the routing rules used to select the outputs for each entry (default: reply to origin)
+func (st *Agent) SetRoutingRules(rules ...botio.RoutingRule) {
+	st.router.SetRules(rules...)
+}
+
+// AddRoutingRule adds a routing rule. Replies are sent to the outputs selected by any rule.
+func (st *Agent) AddRoutingRule(rule botio.RoutingRule) {
+	st.router.AddRule(rule)
+}
+
+// SetTimeoutMessage sets the default message to reply when a handler exceeds its command's timeout
+func (st *Agent) SetTimeoutMessage(message string) {
+	st.cmdManager.SetTimeoutMessage(message)
+}
+
+// Use adds middlewares to wrap the commands' dispatch. The first added middleware is the outermost one.
+func (st *Agent) Use(middlewares ...cmd.CMDMiddleware) {
+	st.cmdManager.Use(middlewares...)
+}
+
+// SetUnknownCMDHandler sets the handler for the entries not matching any command
+func (st *Agent) SetUnknownCMDHandler(handler cmd.CMDHandlerV2) {
+	st.cmdManager.SetUnknownCMDHandler(handler)
+}
+
+// SetRoleChecker sets the checker for the commands' RequiredRoles (i.e. rbac.RBAC)
+func (st *Agent) SetRoleChecker(checker cmd.RoleChecker) {
+	st.cmdManager.SetRoleChecker(checker)
+}
+
+// GetApprovalsCMD returns the command to list, approve and cancel the commands waiting for approval (ApproverRoles)
+func (st *Agent) GetApprovalsCMD(pattern string) cmd.CMD {
+	return st.cmdManager.GetApprovalsCMD(pattern)
+}
+
+// SetApprovalRecorder sets the function to record the approval requests (i.e. audit log)
+func (st *Agent) SetApprovalRecorder(recorder cmd.ApprovalRecorder) {
+	st.cmdManager.SetApprovalRecorder(recorder)
+}
+
 // AddCMD adds a new command
 func (st *Agent) AddCMD(cmd cmd.CMD) error {
 	return st.cmdManager.AddCommand(cmd)
 }
 
+// AddCMDsFromFile adds the commands defined in a YAML / JSON file, handlers are bound by name from the registry
+func (st *Agent) AddCMDsFromFile(path string, registry *cmd.HandlerRegistry) error {
+	cmds, err := cmd.LoadCMDsFromFile(path, registry)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(cmds); i++ {
+		if err := st.cmdManager.AddCommand(cmds[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveCMD removes the command having the given ID. It could be called while the agent is listening.
+func (st *Agent) RemoveCMD(id string) error {
+	return st.cmdManager.RemoveCommand(id)
+}
+
+// ReplaceCMD replaces the command having the given ID. It could be called while the agent is listening.
+func (st *Agent) ReplaceCMD(id string, cmd cmd.CMD) error {
+	return st.cmdManager.ReplaceCommand(id, cmd)
+}
+
+// GetCMDTree returns the description of all commands and their subcommands
+func (st *Agent) GetCMDTree() []cmd.CMDInfo {
+	return st.cmdManager.GetCMDTree()
+}
+
+// WalkCMDs invokes fn for each command in the tree (depth-first, parents first). It stops at the first error.
+func (st *Agent) WalkCMDs(fn func(info cmd.CMDInfo) error) error {
+	return st.cmdManager.Walk(fn)
+}
+
+// ExportCMDsJSON exports the commands' tree as JSON
+func (st *Agent) ExportCMDsJSON() ([]byte, error) {
+	return st.cmdManager.ExportJSON()
+}
+
+// ExportCMDsMarkdown exports the commands' tree as a Markdown reference
+func (st *Agent) ExportCMDsMarkdown() string {
+	return st.cmdManager.ExportMarkdown()
+}
+
+// GetCMDOverlaps returns the commands that could match the same content
+func (st *Agent) GetCMDOverlaps() []cmd.CMDOverlap {
+	return st.cmdManager.GetOverlaps()
+}
+
+// SetShutdownTimeout sets the max time to process the enqueued entries once the ListenContext's context is done
+func (st *Agent) SetShutdownTimeout(timeout time.Duration) {
+	st.shutdownTimeout = timeout
+}
+
+// Listen listens for input entries until all inputs are gone
 func (st *Agent) Listen() error {
+	return st.ListenContext(context.Background())
+}
+
+// ListenContext listens for input entries until all inputs are gone (Listen returned or channel closed), the given
+// context is done or Shutdown is invoked. Once the listener stops, the inputs get stopped (only botio.StoppableInput),
+// the enqueued entries are processed within the shutdown timeout and the outputs are flushed.
+func (st *Agent) ListenContext(ctx context.Context) error {
 	// only one listener could be alive at the same time
+	st.mutex.Lock()
 	if st.isListening {
+		st.mutex.Unlock()
 		return errors.New("Agent is already listening")
 	}
+	if len(st.inputs) == 0 {
+		st.mutex.Unlock()
+		return errors.New("Agent has no inputs")
+	}
 	st.isListening = true
-	defer func() {st.isListening = false}()
+	listenCtx, cancel := context.WithCancel(ctx)
+	st.cancelListen = cancel
+	st.listenDone = make(chan struct{})
+	st.drainCtx = nil
+	st.handlersCtx, st.cancelHandlers = context.WithCancel(context.Background())
+	st.dropEntries = false
+	st.totalProcessed = 0
+	st.mutex.Unlock()
+
+	defer func() {
+		cancel()
+
+		st.mutex.Lock()
+		st.cancelHandlers()
+		st.isListening = false
+		close(st.listenDone)
+		st.mutex.Unlock()
+	}()
 
 	// spin up workers
 	if err := st.workerpool.StartWorkers(); err != nil {
@@ -91,29 +286,87 @@ func (st *Agent) Listen() error {
 	}
 
 	// start listening
-	go st.input.Listen(st.inputChann)
-
-	keepWorking := true
-	for keepWorking {
-		select {
-		case entry, ok := <-st.inputChann:
-			if !ok {
-				// the channel is closed: exit
-				keepWorking = false
-				// process all enqueued input entries and kill the workers
-				st.workerpool.LateKillAllWorkers()
-
-				break
-			}
+	inputsDone := st.startInputs()
 
-			// enqueue the input entry to be processed by a worker
-			st.workerpool.AddTask(entry)
-		}
+	select {
+	case <-listenCtx.Done():
+	case <-inputsDone:
+		// all inputs are gone
 	}
 
-	// wait until all workers are down
-	st.workerpool.Wait()
+	// stop the inputs and enqueue the entries they already sent
+	st.stopInputs()
+
+	// process all enqueued input entries and kill the workers
+	drainCtx, drainCancel := st.getDrainContext()
+	summary := st.drain(drainCtx)
+	drainCancel()
+
+	st.mutex.Lock()
+	st.shutdownSummary = summary
+	st.mutex.Unlock()
+
+	if len(summary.Dropped) > 0 || summary.InFlight > 0 {
+		st.log.Warningf("Agent.Listener is done. Dropped entries: %v, entries still in progress: %v", len(summary.Dropped), summary.InFlight)
+	} else {
+		st.log.Notice("Agent.Listener is done")
+	}
 
-	st.log.Notice("Agent.Listener is done")
 	return nil
 }
+
+// enqueue enqueues an input entry to be processed by a worker. Entries exceeding a rate limit are not enqueued.
+func (st *Agent) enqueue(entry botio.InputEntry) {
+	if !st.checkRateLimits(entry) {
+		return
+	}
+
+	st.mutex.Lock()
+	st.lastEntryID++
+	id := st.lastEntryID
+	st.pendingEntries[id] = entry
+	st.mutex.Unlock()
+
+	if err := st.workerpool.AddTask(enqueuedEntry{id: id, entry: entry}); err != nil {
+		st.mutex.Lock()
+		delete(st.pendingEntries, id)
+		st.mutex.Unlock()
+
+		st.log.Errorf("Entry could not be enqueued: '%v'", err.Error())
+	}
+}
+
+// startProcessing marks the entry as in progress. Returns false if the entry must be dropped.
+func (st *Agent) startProcessing(id uint64) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.dropEntries {
+		return false
+	}
+
+	delete(st.pendingEntries, id)
+	st.totalInFlight++
+	st.totalProcessed++
+
+	return true
+}
+
+// getHandlersContext returns the context for the handlers
+func (st *Agent) getHandlersContext() context.Context {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.handlersCtx == nil {
+		return context.Background()
+	}
+
+	return st.handlersCtx
+}
+
+// endProcessing marks an entry as done
+func (st *Agent) endProcessing() {
+	st.mutex.Lock()
+	st.totalInFlight--
+	st.mutex.Unlock()
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/enriquebris/goagent/cmd"
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ErrorEvent's type for the inputs' failures
+	ErrorTypeInput = "input"
+	// capacity of the channel to receive the errors from the CMDManager
+	errorChannelCapacity = 100
+)
+
+// errorSubscribers keeps the channels subscribed to the error stream
+type errorSubscribers struct {
+	mutex       sync.RWMutex
+	subscribers []chan cmd.ErrorEvent
+}
+
+// SubscribeErrors returns a channel to receive the errors (panics, handlers' errors, timeouts, unknown commands,
+// failing inputs). Errors are discarded for the subscriber if its channel is full.
+func (st *Agent) SubscribeErrors(bufferSize int) <-chan cmd.ErrorEvent {
+	ch := make(chan cmd.ErrorEvent, bufferSize)
+
+	st.errorSubscribers.mutex.Lock()
+	st.errorSubscribers.subscribers = append(st.errorSubscribers.subscribers, ch)
+	st.errorSubscribers.mutex.Unlock()
+
+	return ch
+}
+
+// UnsubscribeErrors removes and closes the given subscriber's channel
+func (st *Agent) UnsubscribeErrors(subscriber <-chan cmd.ErrorEvent) {
+	st.errorSubscribers.mutex.Lock()
+	defer st.errorSubscribers.mutex.Unlock()
+
+	for i := 0; i < len(st.errorSubscribers.subscribers); i++ {
+		if (<-chan cmd.ErrorEvent)(st.errorSubscribers.subscribers[i]) == subscriber {
+			close(st.errorSubscribers.subscribers[i])
+			st.errorSubscribers.subscribers = append(st.errorSubscribers.subscribers[:i], st.errorSubscribers.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetPanicMessage sets the message to reply when a handler panics
+func (st *Agent) SetPanicMessage(message string) {
+	st.cmdManager.SetPanicMessage(message)
+}
+
+// dispatchErrors sends the errors published by the CMDManager to all subscribers
+func (st *Agent) dispatchErrors() {
+	for err := range st.errorChan {
+		event, ok := err.(cmd.ErrorEvent)
+		if !ok {
+			event = cmd.NewErrorEvent(cmd.CMDErrorTypeHandler, err, botio.InputEntry{})
+		}
+
+		st.publishError(event)
+	}
+}
+
+// publishError sends the error to all subscribers (non-blocking)
+func (st *Agent) publishError(event cmd.ErrorEvent) {
+	st.errorSubscribers.mutex.RLock()
+	defer st.errorSubscribers.mutex.RUnlock()
+
+	for i := 0; i < len(st.errorSubscribers.subscribers); i++ {
+		select {
+		case st.errorSubscribers.subscribers[i] <- event:
+		default:
+		}
+	}
+}
+
+// publishInputError publishes an input's failure
+func (st *Agent) publishInputError(name string, err error) {
+	st.publishError(cmd.NewErrorEvent(ErrorTypeInput, errors.Wrapf(err, "input '%v'", name), botio.InputEntry{}))
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/pkg/errors"
+)
+
+const (
+	// name for the input set by SetInput
+	defaultInputName = "default"
+	// capacity of each input's channel
+	inputChannelCapacity = 500
+)
+
+// InputErrorHandler is invoked each time an input's Listen returns an error
+type InputErrorHandler func(name string, err error)
+
+// InputStatus describes the lifecycle of an input
+type InputStatus struct {
+	Name        string
+	IsListening bool
+	// error returned by the last Listen
+	Err       error
+	StartedAt time.Time
+	StoppedAt time.Time
+}
+
+// agentInput is an input managed by the agent
+type agentInput struct {
+	name    string
+	input   botio.Input
+	channel chan botio.InputEntry
+	status  InputStatus
+	// closed once Listen returns
+	listenDone chan struct{}
+}
+
+// SetInput sets the Input. It replaces the previous input set by SetInput, inputs added by AddInput are kept.
+func (st *Agent) SetInput(input botio.Input) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	for i := 0; i < len(st.inputs); i++ {
+		if st.inputs[i].name == defaultInputName {
+			st.inputs = append(st.inputs[:i], st.inputs[i+1:]...)
+			break
+		}
+	}
+
+	st.inputs = append(st.inputs, newAgentInput(defaultInputName, input))
+}
+
+// AddInput adds a named io.Input. All inputs feed the same workerpool.
+func (st *Agent) AddInput(name string, input botio.Input) error {
+	if input == nil {
+		return errors.New("Input can't be nil")
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.isListening {
+		return errors.New("Inputs can't be added while the agent is listening")
+	}
+
+	for i := 0; i < len(st.inputs); i++ {
+		if st.inputs[i].name == name {
+			return errors.Errorf("Input '%v' already exists", name)
+		}
+	}
+
+	st.inputs = append(st.inputs, newAgentInput(name, input))
+
+	return nil
+}
+
+// SetInputErrorHandler sets the function to be invoked each time an input fails
+func (st *Agent) SetInputErrorHandler(handler InputErrorHandler) {
+	st.mutex.Lock()
+	st.inputErrorHandler = handler
+	st.mutex.Unlock()
+}
+
+// GetInputsStatus returns the status of all inputs
+func (st *Agent) GetInputsStatus() []InputStatus {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	ret := make([]InputStatus, 0, len(st.inputs))
+	for i := 0; i < len(st.inputs); i++ {
+		ret = append(ret, st.inputs[i].status)
+	}
+
+	return ret
+}
+
+func newAgentInput(name string, input botio.Input) *agentInput {
+	return &agentInput{
+		name:  name,
+		input: input,
+		status: InputStatus{
+			Name: name,
+		},
+	}
+}
+
+// startInputs starts listening all inputs. The returned channel is closed once all inputs are gone (Listen returned or
+// channel closed).
+func (st *Agent) startInputs() <-chan struct{} {
+	st.mutex.Lock()
+	inputs := make([]*agentInput, len(st.inputs))
+	copy(inputs, st.inputs)
+	st.stopForwarding = make(chan struct{})
+	st.mutex.Unlock()
+
+	st.forwarders = sync.WaitGroup{}
+	for i := 0; i < len(inputs); i++ {
+		inputs[i].channel = make(chan botio.InputEntry, inputChannelCapacity)
+		inputs[i].listenDone = make(chan struct{})
+
+		st.forwarders.Add(1)
+		go st.forwardInput(inputs[i], st.stopForwarding)
+		go st.listenInput(inputs[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		st.forwarders.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+// listenInput runs the input's Listen. A failing input does not affect the rest of the inputs.
+func (st *Agent) listenInput(in *agentInput) {
+	st.mutex.Lock()
+	in.status.IsListening = true
+	in.status.Err = nil
+	in.status.StartedAt = time.Now()
+	st.mutex.Unlock()
+
+	err := in.input.Listen(in.channel)
+	// the input's forwarder enqueues the pending entries and exits
+	close(in.listenDone)
+
+	st.mutex.Lock()
+	in.status.IsListening = false
+	in.status.Err = err
+	in.status.StoppedAt = time.Now()
+	errorHandler := st.inputErrorHandler
+	st.mutex.Unlock()
+
+	if err != nil {
+		st.log.Errorf("Input '%v' Listen: '%v'", in.name, err.Error())
+		st.publishInputError(in.name, err)
+		if errorHandler != nil {
+			errorHandler(in.name, err)
+		}
+	}
+}
+
+// forwardInput enqueues the input's entries until the input closes its channel, its Listen returns or the agent stops
+// forwarding
+func (st *Agent) forwardInput(in *agentInput, stop chan struct{}) {
+	defer st.forwarders.Done()
+
+	for {
+		select {
+		case entry, ok := <-in.channel:
+			if !ok {
+				// the channel is closed: exit
+				return
+			}
+
+			// enqueue the input entry to be processed by a worker
+			st.enqueue(entry)
+
+		case <-in.listenDone:
+			// nothing else will be sent
+			st.drainInput(in)
+			return
+
+		case <-stop:
+			st.drainInput(in)
+			return
+		}
+	}
+}
+
+// drainInput enqueues the entries that are already in the input's channel
+func (st *Agent) drainInput(in *agentInput) {
+	for {
+		select {
+		case entry, ok := <-in.channel:
+			if !ok {
+				return
+			}
+			st.enqueue(entry)
+		default:
+			return
+		}
+	}
+}
+
+// stopInputs stops the inputs (only botio.StoppableInput) and enqueues the entries they already sent
+func (st *Agent) stopInputs() {
+	st.mutex.Lock()
+	inputs := make([]*agentInput, len(st.inputs))
+	copy(inputs, st.inputs)
+	st.mutex.Unlock()
+
+	for i := 0; i < len(inputs); i++ {
+		if stoppable, ok := inputs[i].input.(botio.StoppableInput); ok {
+			if err := stoppable.Stop(); err != nil {
+				st.log.Errorf("Input '%v' Stop: '%v'", inputs[i].name, err.Error())
+			}
+		}
+	}
+
+	close(st.stopForwarding)
+	st.forwarders.Wait()
+}
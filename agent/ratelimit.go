@@ -0,0 +1,275 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/enriquebris/goagent/cmd"
+	botio "github.com/enriquebris/goagent/io"
+)
+
+const (
+	RateLimitScopeUser  = "user"
+	RateLimitScopeWhere = "where"
+	RateLimitScopeCMD   = "cmd"
+
+	// full buckets are removed once a limiter has more buckets than this
+	maxRateLimitBuckets = 10000
+)
+
+// RateLimit is a token bucket's configuration: Requests are allowed per Interval, up to Burst at once
+type RateLimit struct {
+	Requests int
+	Interval time.Duration
+	// max requests at once (bucket's capacity), Requests if zero
+	Burst int
+}
+
+// isEnabled returns true whether the rate limit limits something
+func (st RateLimit) isEnabled() bool {
+	return st.Requests > 0 && st.Interval > 0
+}
+
+// getBurst returns the bucket's capacity
+func (st RateLimit) getBurst() float64 {
+	if st.Burst > 0 {
+		return float64(st.Burst)
+	}
+
+	return float64(st.Requests)
+}
+
+// tokenBucket is the state of a single key (user, where, command)
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// whether the throttled handler was already invoked since the last allowed entry
+	notified bool
+}
+
+// rateLimiter keeps a token bucket per key
+type rateLimiter struct {
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// getBucket returns the key's bucket refilled up to now
+func (st *rateLimiter) getBucket(key string, now time.Time) *tokenBucket {
+	bucket, ok := st.buckets[key]
+	if !ok {
+		if len(st.buckets) >= maxRateLimitBuckets {
+			st.removeFullBuckets(now)
+		}
+
+		bucket = &tokenBucket{tokens: st.limit.getBurst(), last: now}
+		st.buckets[key] = bucket
+		return bucket
+	}
+
+	st.refill(bucket, now)
+	return bucket
+}
+
+// refill adds the tokens generated since the bucket's last update
+func (st *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	if elapsed <= 0 {
+		return
+	}
+
+	bucket.tokens += elapsed.Seconds() * float64(st.limit.Requests) / st.limit.Interval.Seconds()
+	if burst := st.limit.getBurst(); bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+}
+
+// getRetryAfter returns the time until the bucket gets a token
+func (st *rateLimiter) getRetryAfter(bucket *tokenBucket) time.Duration {
+	missing := 1 - bucket.tokens
+	if missing <= 0 {
+		return 0
+	}
+
+	return time.Duration(missing * float64(st.limit.Interval) / float64(st.limit.Requests))
+}
+
+// removeFullBuckets removes the buckets having all their tokens, they are equivalent to new ones
+func (st *rateLimiter) removeFullBuckets(now time.Time) {
+	for key, bucket := range st.buckets {
+		st.refill(bucket, now)
+		if bucket.tokens >= st.limit.getBurst() {
+			delete(st.buckets, key)
+		}
+	}
+}
+
+// rateLimits holds the agent's rate limiters
+type rateLimits struct {
+	user  *rateLimiter
+	where *rateLimiter
+	// by top level command's ID
+	cmds  map[string]*rateLimiter
+	mutex sync.Mutex
+	// throttled handlers running, they don't block the inputs' forwarders
+	replies sync.WaitGroup
+}
+
+// rateLimitCheck is a bucket to be checked for an entry
+type rateLimitCheck struct {
+	scope   string
+	limiter *rateLimiter
+	key     string
+}
+
+// SetUserRateLimit limits the entries per user (GeneralMetadataFieldUserID). A zero RateLimit removes the limit.
+func (st *Agent) SetUserRateLimit(limit RateLimit) {
+	st.rateLimits.mutex.Lock()
+	defer st.rateLimits.mutex.Unlock()
+
+	st.rateLimits.user = nil
+	if limit.isEnabled() {
+		st.rateLimits.user = newRateLimiter(limit)
+	}
+}
+
+// SetWhereRateLimit limits the entries per channel (GeneralMetadataFieldWhere). A zero RateLimit removes the limit.
+func (st *Agent) SetWhereRateLimit(limit RateLimit) {
+	st.rateLimits.mutex.Lock()
+	defer st.rateLimits.mutex.Unlock()
+
+	st.rateLimits.where = nil
+	if limit.isEnabled() {
+		st.rateLimits.where = newRateLimiter(limit)
+	}
+}
+
+// SetCMDRateLimit limits the entries per user for the top level command having the given ID. A zero RateLimit
+// removes the limit.
+func (st *Agent) SetCMDRateLimit(cmdID string, limit RateLimit) {
+	st.rateLimits.mutex.Lock()
+	defer st.rateLimits.mutex.Unlock()
+
+	delete(st.rateLimits.cmds, cmdID)
+	if limit.isEnabled() {
+		st.rateLimits.cmds[cmdID] = newRateLimiter(limit)
+	}
+}
+
+// SetThrottledHandler sets the handler for the entries exceeding any rate limit. It is invoked once per throttled
+// user / channel / command until an entry gets allowed again.
+func (st *Agent) SetThrottledHandler(handler cmd.CMDHandlerV2) {
+	st.cmdManager.SetThrottledHandler(handler)
+}
+
+// checkRateLimits takes a token from each bucket the entry belongs to. No tokens are taken if any bucket is empty.
+// Returns true whether the entry is allowed. The throttled handler runs in background.
+func (st *Agent) checkRateLimits(entry botio.InputEntry) bool {
+	checks := st.getRateLimitChecks(entry)
+	if len(checks) == 0 {
+		return true
+	}
+
+	st.rateLimits.mutex.Lock()
+	now := time.Now()
+	for _, check := range checks {
+		bucket := check.limiter.getBucket(check.key, now)
+		if bucket.tokens >= 1 {
+			continue
+		}
+
+		// throttled
+		retryAfter := check.limiter.getRetryAfter(bucket)
+		notify := !bucket.notified
+		bucket.notified = true
+		st.rateLimits.mutex.Unlock()
+
+		st.log.Warningf("Entry throttled by the %v rate limit (%v): '%v'", check.scope, check.key, entry.Query)
+		if notify {
+			st.notifyThrottled(entry, map[string]interface{}{
+				cmd.CMDExtraDataThrottledScope:      check.scope,
+				cmd.CMDExtraDataThrottledRetryAfter: retryAfter,
+			})
+		}
+
+		return false
+	}
+
+	for _, check := range checks {
+		bucket := check.limiter.getBucket(check.key, now)
+		bucket.tokens--
+		bucket.notified = false
+	}
+	st.rateLimits.mutex.Unlock()
+
+	return true
+}
+
+// notifyThrottled runs the throttled handler in background, so a slow reply doesn't delay the rest of the entries
+func (st *Agent) notifyThrottled(entry botio.InputEntry, extraData map[string]interface{}) {
+	ctx := st.getHandlersContext()
+	outputs := st.router.Route(entry, st.outputs)
+
+	st.rateLimits.replies.Add(1)
+	go func() {
+		defer st.rateLimits.replies.Done()
+
+		if err := st.cmdManager.ProcessThrottled(ctx, entry, outputs, extraData); err != nil {
+			st.log.Errorf("st.cmdManager.ProcessThrottled: '%v'", err.Error())
+		}
+	}()
+}
+
+// getRateLimitChecks returns the buckets to be checked for the entry
+func (st *Agent) getRateLimitChecks(entry botio.InputEntry) []rateLimitCheck {
+	st.rateLimits.mutex.Lock()
+	user := st.rateLimits.user
+	where := st.rateLimits.where
+	totalCMDs := len(st.rateLimits.cmds)
+	st.rateLimits.mutex.Unlock()
+
+	ret := make([]rateLimitCheck, 0, 3)
+
+	userID, hasUser := entry.GeneralMetadata[botio.GeneralMetadataFieldUserID]
+	if user != nil && hasUser {
+		ret = append(ret, rateLimitCheck{
+			scope:   RateLimitScopeUser,
+			limiter: user,
+			key:     fmt.Sprintf("%v:%v", entry.Origin, userID),
+		})
+	}
+
+	if whereID, ok := entry.GeneralMetadata[botio.GeneralMetadataFieldWhere]; where != nil && ok {
+		ret = append(ret, rateLimitCheck{
+			scope:   RateLimitScopeWhere,
+			limiter: where,
+			key:     fmt.Sprintf("%v:%v", entry.Origin, whereID),
+		})
+	}
+
+	if totalCMDs > 0 && hasUser {
+		if cmdID := st.cmdManager.GetMatchingCMDID(entry.Query); cmdID != "" {
+			st.rateLimits.mutex.Lock()
+			limiter := st.rateLimits.cmds[cmdID]
+			st.rateLimits.mutex.Unlock()
+
+			if limiter != nil {
+				ret = append(ret, rateLimitCheck{
+					scope:   RateLimitScopeCMD,
+					limiter: limiter,
+					key:     fmt.Sprintf("%v:%v:%v", cmdID, entry.Origin, userID),
+				})
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"sort"
+
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/pkg/errors"
+)
+
+// ShutdownSummary summarizes what happened with the entries once the agent stopped listening
+type ShutdownSummary struct {
+	// entries picked by a worker since the agent started listening
+	Processed int
+	// entries still in progress when the drain deadline was reached
+	InFlight int
+	// enqueued entries that were not processed because the drain deadline was reached
+	Dropped []botio.InputEntry
+}
+
+// Shutdown stops the listener, processes the enqueued entries until ctx is done and flushes the outputs.
+// Returns a summary of the entries that could not be processed, along with ctx.Err() if the deadline was reached
+// before all enqueued entries were processed.
+func (st *Agent) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	st.mutex.Lock()
+	if !st.isListening {
+		st.mutex.Unlock()
+		return ShutdownSummary{}, errors.New("Agent is not listening")
+	}
+	st.drainCtx = ctx
+	cancel := st.cancelListen
+	done := st.listenDone
+	st.mutex.Unlock()
+
+	// stop listening
+	cancel()
+	// wait until the enqueued entries get processed (or dropped)
+	<-done
+
+	summary := st.GetShutdownSummary()
+	if len(summary.Dropped) > 0 || summary.InFlight > 0 {
+		return summary, ctx.Err()
+	}
+
+	return summary, nil
+}
+
+// GetShutdownSummary returns the summary from the last time the agent stopped listening
+func (st *Agent) GetShutdownSummary() ShutdownSummary {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	return st.shutdownSummary
+}
+
+// getDrainContext returns the context to be used to process the enqueued entries once the listener is done
+func (st *Agent) getDrainContext() (context.Context, context.CancelFunc) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.drainCtx != nil {
+		return st.drainCtx, func() {}
+	}
+
+	return context.WithTimeout(context.Background(), st.shutdownTimeout)
+}
+
+// drain processes all enqueued entries, kills the workers and flushes the outputs.
+// Enqueued entries not processed before ctx is done are dropped.
+func (st *Agent) drain(ctx context.Context) ShutdownSummary {
+	// kill the workers once all enqueued entries get processed
+	st.workerpool.LateKillAllWorkers()
+
+	workersDone := make(chan struct{})
+	go func() {
+		// wait until all workers are down
+		st.workerpool.Wait()
+		// and the throttled replies are sent
+		st.rateLimits.replies.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		// deadline reached: the remaining enqueued entries will be dropped by the workers
+		// and the in-progress handlers get cancelled
+		st.mutex.Lock()
+		st.dropEntries = true
+		st.cancelHandlers()
+		st.mutex.Unlock()
+	}
+
+	st.flushOutputs()
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	summary := ShutdownSummary{
+		Processed: st.totalProcessed,
+		InFlight:  st.totalInFlight,
+	}
+	// dropped entries sorted by arrival
+	ids := make([]uint64, 0, len(st.pendingEntries))
+	for id := range st.pendingEntries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		summary.Dropped = append(summary.Dropped, st.pendingEntries[id])
+		delete(st.pendingEntries, id)
+	}
+
+	return summary
+}
+
+// flushOutputs flushes all botio.FlushableOutput outputs
+func (st *Agent) flushOutputs() {
+	for i := 0; i < len(st.outputs); i++ {
+		if flushable, ok := st.outputs[i].(botio.FlushableOutput); ok {
+			if err := flushable.Flush(); err != nil {
+				st.log.Errorf("Output.Flush: '%v'", err.Error())
+			}
+		}
+	}
+}
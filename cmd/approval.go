@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goagent/message"
+)
+
+const (
+	// default time to approve a command
+	defaultApprovalTimeout = 30 * time.Minute
+	// default pattern for the approvals' command (see GetApprovalsCMD)
+	defaultApprovalsPattern = "approvals"
+
+	ApprovalStatusPending   = "pending"
+	ApprovalStatusApproved  = "approved"
+	ApprovalStatusCancelled = "cancelled"
+	ApprovalStatusExpired   = "expired"
+
+	approvalParamID = "id"
+
+	approvalRequestedMessage   = "Approval request #%v: '%v' requested by %v needs the approval of another user (roles: %v). Reply `%v approve %v` within %v."
+	approvalApprovedMessage    = "Approval request #%v: '%v' approved by %v."
+	approvalCancelledMessage   = "Approval request #%v: '%v' cancelled by %v."
+	approvalExpiredMessage     = "Approval request #%v: '%v' expired."
+	approvalCommandGoneMessage = "Approval request #%v: '%v' cancelled, the command was removed or replaced."
+	approvalNoUserMessage      = "Sorry, '%v' needs approval but your user can't be identified."
+	approvalUnknownMessage     = "Sorry, there is no pending approval request #%v."
+	approvalSelfMessage        = "Sorry, approval request #%v must be approved by a different user."
+	approvalNotAllowedMessage  = "Sorry, you are not allowed to approve / cancel approval request #%v."
+	approvalNoneMessage        = "There are no pending approval requests."
+	approvalListItemMessage    = "\n#%v: '%v' requested by %v at %v, expires at %v"
+	approvalUsageMessage       = "Usage:\n\t%[1]v list\n\t%[1]v approve <id>\n\t%[1]v cancel <id>"
+	approvalsPendingHeaderText = "Pending approval requests:"
+)
+
+// ApprovalRecord describes an approval request
+type ApprovalRecord struct {
+	ID string
+	// matching command's pattern and the content
+	Pattern string
+	Query   string
+	Status  string
+	// requester's user ID (GeneralMetadataFieldUserID) and name
+	RequestedByID string
+	RequestedBy   string
+	RequestedAt   time.Time
+	ExpiresAt     time.Time
+	// approver / canceller's user ID and name
+	DecidedByID string
+	DecidedBy   string
+	DecidedAt   time.Time
+}
+
+// ApprovalRecorder is invoked each time an approval request is created, approved, cancelled or expires
+type ApprovalRecorder func(record ApprovalRecord)
+
+// approvalRequest is a request waiting for approval
+type approvalRequest struct {
+	record  ApprovalRecord
+	request CMDRequest
+	timer   *time.Timer
+}
+
+// pendingApprovals keeps the requests waiting for approval, by ID
+type pendingApprovals struct {
+	requests map[string]*approvalRequest
+	lastID   uint64
+	mutex    sync.Mutex
+}
+
+// SetApprovalRecorder sets the function to record the approval requests (i.e. audit log). They are logged by default.
+func (st *CMDManager) SetApprovalRecorder(recorder ApprovalRecorder) {
+	st.approvalRecorder = recorder
+}
+
+// GetPendingApprovals returns the approval requests waiting for approval, oldest first
+func (st *CMDManager) GetPendingApprovals() []ApprovalRecord {
+	st.pendingApprovals.mutex.Lock()
+	defer st.pendingApprovals.mutex.Unlock()
+
+	ret := make([]ApprovalRecord, 0, len(st.pendingApprovals.requests))
+	for _, approval := range st.pendingApprovals.requests {
+		ret = append(ret, approval.record)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].RequestedAt.Before(ret[j].RequestedAt)
+	})
+
+	return ret
+}
+
+// GetApprovalsCMD returns the command to list, approve and cancel the approval requests:
+//
+//	<pattern> list
+//	<pattern> approve <id>
+//	<pattern> cancel <id>
+//
+// Only a user different from the requester having any of the command's ApproverRoles could approve it. The requester
+// could cancel it too.
+func (st *CMDManager) GetApprovalsCMD(pattern string) CMD {
+	st.approvalsPattern = pattern
+
+	idParams := []CMDParam{
+		{ID: approvalParamID, Description: "approval request ID", Type: CMDParamTypeString, Required: true},
+	}
+	usageHandler := func(request CMDRequest) error {
+		message.SendMessageToOutput(fmt.Sprintf(approvalUsageMessage, pattern), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	return CMD{
+		PatternType: CMDTypeWord,
+		Pattern:     []string{pattern},
+		Description: "Approval requests",
+		HandlerV2:   usageHandler,
+		SubCommands: []CMD{
+			{
+				PatternType: CMDTypeWord,
+				Pattern:     []string{"list"},
+				Description: "Lists the pending approval requests",
+				HandlerV2:   st.listApprovals,
+			},
+			{
+				PatternType:            CMDTypeWord,
+				Pattern:                []string{"approve"},
+				Description:            "Approves a request",
+				Params:                 idParams,
+				HandlerV2:              usageHandler,
+				HandlerParamsV2:        st.approve,
+				HandlerParamsMissingV2: usageHandler,
+				HandlerParamsExtraV2:   usageHandler,
+			},
+			{
+				PatternType:            CMDTypeWord,
+				Pattern:                []string{"cancel"},
+				Description:            "Cancels a request",
+				Params:                 idParams,
+				HandlerV2:              usageHandler,
+				HandlerParamsV2:        st.cancelApproval,
+				HandlerParamsMissingV2: usageHandler,
+				HandlerParamsExtraV2:   usageHandler,
+			},
+		},
+	}
+}
+
+// dispatchOrRequestApproval runs the request's handler, or posts an approval request if it is an execution of a CMD
+// needing approval
+func (st *CMDManager) dispatchOrRequestApproval(request CMDRequest) error {
+	if isExecution(request.HandlerType) && len(request.CMD.ApproverRoles) > 0 {
+		st.requestApproval(request)
+		return nil
+	}
+
+	return st.dispatch(request)
+}
+
+// requestApproval saves the request as pending and posts the approval request. Requests from unidentified users (no
+// GeneralMetadataFieldUserID) are refused, the requester must be recorded.
+func (st *CMDManager) requestApproval(request CMDRequest) {
+	requesterID, ok := getUserID(request.Entry)
+	if !ok {
+		message.SendMessageToOutput(fmt.Sprintf(approvalNoUserMessage, request.Pattern), request.Entry.InputMetadata, nil, request.Outputs)
+		return
+	}
+
+	timeout := request.CMD.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	now := time.Now()
+
+	st.pendingApprovals.mutex.Lock()
+	if st.pendingApprovals.requests == nil {
+		st.pendingApprovals.requests = make(map[string]*approvalRequest)
+	}
+	st.pendingApprovals.lastID++
+	id := strconv.FormatUint(st.pendingApprovals.lastID, 10)
+	approval := &approvalRequest{
+		record: ApprovalRecord{
+			ID:            id,
+			Pattern:       request.Pattern,
+			Query:         strings.TrimSpace(request.Entry.Query),
+			Status:        ApprovalStatusPending,
+			RequestedByID: requesterID,
+			RequestedBy:   getRequester(request.Entry),
+			RequestedAt:   now,
+			ExpiresAt:     now.Add(timeout),
+		},
+		request: request,
+	}
+	st.pendingApprovals.requests[id] = approval
+	// expire automatically
+	approval.timer = time.AfterFunc(timeout, func() {
+		st.expireApproval(id)
+	})
+	record := approval.record
+	st.pendingApprovals.mutex.Unlock()
+
+	st.recordApproval(record)
+
+	approvalsPattern := st.approvalsPattern
+	if approvalsPattern == "" {
+		approvalsPattern = defaultApprovalsPattern
+	}
+	message.SendMessageToOutput(
+		fmt.Sprintf(approvalRequestedMessage, id, record.Query, record.RequestedBy, strings.Join(request.CMD.ApproverRoles, ", "), approvalsPattern, id, formatDuration(timeout)),
+		request.Entry.InputMetadata,
+		nil,
+		request.Outputs,
+	)
+}
+
+// approve is the handler to approve a request. The approved request's handler runs, its replies go to the requester.
+func (st *CMDManager) approve(request CMDRequest) error {
+	id := getApprovalID(request)
+	approverID := fmt.Sprint(request.Entry.GeneralMetadata[botio.GeneralMetadataFieldUserID])
+
+	st.pendingApprovals.mutex.Lock()
+	approval, ok := st.pendingApprovals.requests[id]
+	if !ok {
+		st.pendingApprovals.mutex.Unlock()
+		message.SendMessageToOutput(fmt.Sprintf(approvalUnknownMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+	requesterID := approval.record.RequestedByID
+	approverRoles := approval.request.CMD.ApproverRoles
+	st.pendingApprovals.mutex.Unlock()
+
+	// two different users
+	if _, hasUserID := request.Entry.GeneralMetadata[botio.GeneralMetadataFieldUserID]; !hasUserID || approverID == requesterID {
+		message.SendMessageToOutput(fmt.Sprintf(approvalSelfMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	if !st.isApprover(approverID, approverRoles) {
+		message.SendMessageToOutput(fmt.Sprintf(approvalNotAllowedMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	record, ok := st.decideApproval(id, ApprovalStatusApproved, request.Entry)
+	if !ok {
+		// approved / cancelled / expired meanwhile
+		message.SendMessageToOutput(fmt.Sprintf(approvalUnknownMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	text := fmt.Sprintf(approvalApprovedMessage, id, record.Query, record.DecidedBy)
+	message.SendMessageToOutput(text, request.Entry.InputMetadata, nil, request.Outputs)
+	message.SendMessageToOutput(text, approval.request.Entry.InputMetadata, nil, approval.request.Outputs)
+
+	// run the approved request, both users are passed to the handler
+	approved := approval.request
+	approved.Context = request.Context
+	metadata := make(map[string]interface{}, len(approved.Metadata)+3)
+	for key, value := range approved.Metadata {
+		metadata[key] = value
+	}
+	metadata[CMDExtraDataApprovalID] = record.ID
+	metadata[CMDExtraDataApprovalRequestedBy] = record.RequestedBy
+	metadata[CMDExtraDataApprovalApprovedBy] = record.DecidedBy
+	approved.Metadata = metadata
+
+	return st.dispatch(approved)
+}
+
+// cancelApproval is the handler to cancel a request. The requester or an approver could cancel it.
+func (st *CMDManager) cancelApproval(request CMDRequest) error {
+	id := getApprovalID(request)
+	userID := fmt.Sprint(request.Entry.GeneralMetadata[botio.GeneralMetadataFieldUserID])
+
+	st.pendingApprovals.mutex.Lock()
+	approval, ok := st.pendingApprovals.requests[id]
+	if !ok {
+		st.pendingApprovals.mutex.Unlock()
+		message.SendMessageToOutput(fmt.Sprintf(approvalUnknownMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+	requesterID := approval.record.RequestedByID
+	approverRoles := approval.request.CMD.ApproverRoles
+	st.pendingApprovals.mutex.Unlock()
+
+	_, hasUserID := request.Entry.GeneralMetadata[botio.GeneralMetadataFieldUserID]
+	if !hasUserID || (userID != requesterID && !st.isApprover(userID, approverRoles)) {
+		message.SendMessageToOutput(fmt.Sprintf(approvalNotAllowedMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	record, ok := st.decideApproval(id, ApprovalStatusCancelled, request.Entry)
+	if !ok {
+		message.SendMessageToOutput(fmt.Sprintf(approvalUnknownMessage, id), request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	message.SendMessageToOutput(fmt.Sprintf(approvalCancelledMessage, id, record.Query, record.DecidedBy), request.Entry.InputMetadata, nil, request.Outputs)
+
+	return nil
+}
+
+// listApprovals is the handler to list the pending requests
+func (st *CMDManager) listApprovals(request CMDRequest) error {
+	approvals := st.GetPendingApprovals()
+	if len(approvals) == 0 {
+		message.SendMessageToOutput(approvalNoneMessage, request.Entry.InputMetadata, nil, request.Outputs)
+		return nil
+	}
+
+	text := approvalsPendingHeaderText
+	for _, approval := range approvals {
+		text += fmt.Sprintf(approvalListItemMessage, approval.ID, approval.Query, approval.RequestedBy, approval.RequestedAt.Format(time.RFC3339), approval.ExpiresAt.Format(time.RFC3339))
+	}
+	message.SendMessageToOutput(text, request.Entry.InputMetadata, nil, request.Outputs)
+
+	return nil
+}
+
+// expireApproval removes the request once its approval time is over, the requester gets notified
+func (st *CMDManager) expireApproval(id string) {
+	st.pendingApprovals.mutex.Lock()
+	approval, ok := st.pendingApprovals.requests[id]
+	if !ok {
+		st.pendingApprovals.mutex.Unlock()
+		return
+	}
+	delete(st.pendingApprovals.requests, id)
+	approval.record.Status = ApprovalStatusExpired
+	record := approval.record
+	st.pendingApprovals.mutex.Unlock()
+
+	st.recordApproval(record)
+	message.SendMessageToOutput(fmt.Sprintf(approvalExpiredMessage, id, record.Query), approval.request.Entry.InputMetadata, nil, approval.request.Outputs)
+}
+
+// cancelApprovalsByRootID cancels the pending requests for the top level command having the given ID (removed or
+// replaced), the requesters get notified
+func (st *CMDManager) cancelApprovalsByRootID(rootID string) {
+	st.pendingApprovals.mutex.Lock()
+	cancelled := make([]*approvalRequest, 0)
+	for id, approval := range st.pendingApprovals.requests {
+		if approval.request.CMD.rootID != rootID {
+			continue
+		}
+
+		delete(st.pendingApprovals.requests, id)
+		approval.timer.Stop()
+		approval.record.Status = ApprovalStatusCancelled
+		approval.record.DecidedAt = time.Now()
+		cancelled = append(cancelled, approval)
+	}
+	st.pendingApprovals.mutex.Unlock()
+
+	for _, approval := range cancelled {
+		st.recordApproval(approval.record)
+		message.SendMessageToOutput(fmt.Sprintf(approvalCommandGoneMessage, approval.record.ID, approval.record.Query), approval.request.Entry.InputMetadata, nil, approval.request.Outputs)
+	}
+}
+
+// decideApproval removes the pending request recording the decision (approved / cancelled) and who made it.
+// Returns false if the request is not pending anymore.
+func (st *CMDManager) decideApproval(id string, status string, entry botio.InputEntry) (ApprovalRecord, bool) {
+	st.pendingApprovals.mutex.Lock()
+	approval, ok := st.pendingApprovals.requests[id]
+	if !ok {
+		st.pendingApprovals.mutex.Unlock()
+		return ApprovalRecord{}, false
+	}
+	delete(st.pendingApprovals.requests, id)
+	approval.timer.Stop()
+
+	approval.record.Status = status
+	approval.record.DecidedByID = fmt.Sprint(entry.GeneralMetadata[botio.GeneralMetadataFieldUserID])
+	approval.record.DecidedBy = getRequester(entry)
+	approval.record.DecidedAt = time.Now()
+	record := approval.record
+	st.pendingApprovals.mutex.Unlock()
+
+	st.recordApproval(record)
+
+	return record, true
+}
+
+// isApprover returns true whether the user has any of the approver roles
+func (st *CMDManager) isApprover(userID string, approverRoles []string) bool {
+	if st.roleChecker == nil {
+		return false
+	}
+
+	isApprover, err := st.roleChecker.HasAnyRole(userID, approverRoles)
+	if err != nil {
+		log.Printf("Approver roles %v cannot be checked for user '%v': %v", approverRoles, userID, err.Error())
+		return false
+	}
+
+	return isApprover
+}
+
+// recordApproval records the approval request (ApprovalRecorder or log)
+func (st *CMDManager) recordApproval(record ApprovalRecord) {
+	if st.approvalRecorder != nil {
+		st.approvalRecorder(record)
+		return
+	}
+
+	decidedBy := ""
+	if record.DecidedByID != "" {
+		decidedBy = fmt.Sprintf(", decided by %v (%v)", record.DecidedBy, record.DecidedByID)
+	}
+	log.Printf("Approval request #%v '%v' %v. Requested by %v (%v)%v", record.ID, record.Query, record.Status, record.RequestedBy, record.RequestedByID, decidedBy)
+}
+
+// getApprovalID returns the approval request ID param (# prefix allowed)
+func getApprovalID(request CMDRequest) string {
+	param, err := request.GetParam(approvalParamID)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(param.Value, "#")
+}
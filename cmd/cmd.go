@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	botio "github.com/enriquebris/goagent/io"
 )
@@ -19,15 +22,57 @@ const (
 	CMDHandlerTypeParamsWrongType = "handler.params.wrong.type"
 	CMDHandlerTypeParamsMissing   = "handler.params.missing"
 	CMDHandlerTypeParamsExtra     = "handler.params.extra"
+	CMDHandlerTypeUnknown         = "handler.unknown"
+	CMDHandlerTypeThrottled       = "handler.throttled"
+	CMDHandlerTypeBusy            = "handler.busy"
 
 	CMDExtraDataParametersIncorrectType = "extra.data.parameters.incorrect.type"
 	CMDExtraDataParametersMissing       = "extra.data.parameters.missing"
 	CMDExtraDataParametersExtra         = "extra.data.parameters.extra"
+	// closest patterns ([]string) to the unknown command / subcommand
+	CMDExtraDataSuggestions = "extra.data.suggestions"
+	// branch of the restrictions' expression that failed (string), i.e.: and[1] > or
+	CMDExtraDataRestrictionFailedBranch = "extra.data.restriction.failed.branch"
+	// RestrictionResult for the failed restrictions
+	CMDExtraDataRestrictionResult = "extra.data.restriction.result"
+	// rate limit's scope (string) exceeded by a throttled entry: user, where, cmd
+	CMDExtraDataThrottledScope = "extra.data.throttled.scope"
+	// time (time.Duration) until the next entry would be allowed
+	CMDExtraDataThrottledRetryAfter = "extra.data.throttled.retry.after"
+	// why the command is busy (string): CMDBusyReasonRunning, CMDBusyReasonCooldown
+	CMDExtraDataBusyReason = "extra.data.busy.reason"
+	// user (string) who started the running / last execution
+	CMDExtraDataBusyStartedBy = "extra.data.busy.started.by"
+	// start time (time.Time) of the running / last execution
+	CMDExtraDataBusyStartedAt = "extra.data.busy.started.at"
+	// time (time.Time) the command could run again (cooldown only)
+	CMDExtraDataBusyAvailableAt = "extra.data.busy.available.at"
+	// approval request's ID (string) of an approved command
+	CMDExtraDataApprovalID = "extra.data.approval.id"
+	// user (string) who requested the approved command
+	CMDExtraDataApprovalRequestedBy = "extra.data.approval.requested.by"
+	// user (string) who approved the command
+	CMDExtraDataApprovalApprovedBy = "extra.data.approval.approved.by"
+
+	// restrictions checked against the general metadata (default)
+	CMDRestrictionSourceGeneral = "general"
+	// restrictions checked against the input metadata (exactly as it comes from the origin)
+	CMDRestrictionSourceInput = "input"
 
 	// CMD restriction, includes only the listed elements
 	CMDRestrictionConceptInclude = "restriction.include"
 	// CMD restriction, excludes the listed elements, allows all others
 	CMDRestrictionConceptExclude = "restriction.exclude"
+	// CMD restriction, the value must match any of the listed regular expressions
+	CMDRestrictionConceptRegex = "restriction.regex"
+	// CMD restriction, the value must start with any of the listed elements
+	CMDRestrictionConceptPrefix = "restriction.prefix"
+	// CMD restriction, the value must end with any of the listed elements
+	CMDRestrictionConceptSuffix = "restriction.suffix"
+	// CMD restriction, the numeric value must be in [min, max] (nil for no limit)
+	CMDRestrictionConceptRange = "restriction.range"
+	// CMD restriction, the time (current time if no field) must be in [days, hours, location], i.e. "mon-fri", "09:00-17:00", "UTC"
+	CMDRestrictionConceptTimeWindow = "restriction.time.window"
 )
 
 // CMDHandler is the function handler
@@ -42,6 +87,8 @@ const (
 // inputMetadata botio.Metadata		==> Metadata from the input (exactly as it comes from the origin)
 // generalMetadata botio.Metadata	==> General metadata (some values transformed into general fields: user, where, ...)
 // outputs botio.Output				==> Output interface
+//
+// Deprecated: use CMDHandlerV2 (AdaptCMDHandler wraps existing CMDHandler funcs)
 type CMDHandler func(cmd CMD, pattern string, cmdContent string, metadata botio.Metadata, handlerType string, inputMetadata botio.Metadata, generalMetadata botio.Metadata, outputs []botio.Output)
 
 // ***********************************************************************************************
@@ -50,6 +97,8 @@ type CMDHandler func(cmd CMD, pattern string, cmdContent string, metadata botio.
 
 // CMD command
 type CMD struct {
+	// unique ID (top level commands) to remove / replace the command at runtime
+	ID                     string
 	PatternType            string
 	Pattern                []string
 	Description            string
@@ -64,9 +113,56 @@ type CMD struct {
 	HandlerParamsExtra     CMDHandler
 	SubCommands            []CMD
 	Params                 []CMDParam
-	params2map             bool
-	mpParams               map[string]CMDParam
 	GeneralRestrictions    []Restriction
+	// restrictions' expression (AND / OR / NOT), evaluated along with GeneralRestrictions
+	Restrictions *RestrictionExpr
+	// restrictions for the command and all its subcommands. A subcommand's subtree restriction having the same ID
+	// overrides the inherited one.
+	SubtreeRestrictions []Restriction
+	// SubtreeRestrictions along with the inherited ones (set by CMDManager)
+	subtreeRestrictions []Restriction
+	// the user needs at least one of these roles (checked by the CMDManager's RoleChecker) to execute the command and
+	// its subcommands
+	RequiredRoles []string
+	// max time for the handler to finish (inherited by subcommands). The handler's context gets cancelled.
+	Timeout time.Duration
+	// message to reply when the timeout is exceeded (inherited by subcommands)
+	TimeoutMessage string
+	// commands having higher priority are matched first. Same priority: registration order.
+	Priority int
+	// max executions at once (0: no limit), within the ConcurrencyScope
+	MaxConcurrency int
+	// CMDConcurrencyScopeGlobal (default) or CMDConcurrencyScopeWhere, for MaxConcurrency and Cooldown
+	ConcurrencyScope string
+	// min time between executions' starts, within the ConcurrencyScope
+	Cooldown time.Duration
+	// the handler runs only once the same user confirms (yes <token>) in the same where / thread
+	RequireConfirmation bool
+	// confirmation prompt, i.e. "This will delete prod cache"
+	ConfirmationMessage string
+	// time to confirm, 60s by default
+	ConfirmationTimeout time.Duration
+	// the handler runs only once a different user having any of these roles approves it (see GetApprovalsCMD)
+	ApproverRoles []string
+	// time to approve, 30m by default
+	ApprovalTimeout time.Duration
+	// top level command's ID (or first pattern) followed by the subcommands' first patterns (set by CMDManager),
+	// it identifies the command's executions
+	path string
+	// top level command's ID (set by CMDManager), its pending confirmations / approvals are cancelled once it gets
+	// removed or replaced
+	rootID string
+
+	// CMDHandlerV2 handlers, they have priority over the CMDHandler ones
+	HandlerV2                CMDHandlerV2
+	HandlerErrorV2           CMDHandlerV2
+	HandlerRestrictionsV2    CMDHandlerV2
+	HandlerParamsV2          CMDHandlerV2
+	HandlerParamsWrongTypeV2 CMDHandlerV2
+	HandlerParamsMissingV2   CMDHandlerV2
+	HandlerParamsExtraV2     CMDHandlerV2
+	// invoked if MaxConcurrency / Cooldown don't allow the execution, a default message is sent if nil
+	HandlerBusyV2 CMDHandlerV2
 }
 
 type Restriction struct {
@@ -74,6 +170,8 @@ type Restriction struct {
 	Concept string
 	Field   string
 	Data    []interface{}
+	// metadata to check: CMDRestrictionSourceGeneral (default) or CMDRestrictionSourceInput
+	Source string
 }
 
 // AddSubCMD adds a sub command
@@ -92,12 +190,10 @@ func (st *CMD) addCompiledRegex(regx *regexp.Regexp) {
 
 // GetParamByID returns a CMDParam given its ID
 func (st *CMD) GetParamByID(id string) (CMDParam, error) {
-	if !st.params2map {
-		st.convertParamsToMap()
-	}
-
-	if param, ok := st.mpParams[id]; ok {
-		return param, nil
+	for i := 0; i < len(st.Params); i++ {
+		if st.Params[i].ID == id {
+			return st.Params[i], nil
+		}
 	}
 
 	return CMDParam{}, fmt.Errorf("No param '%v'", id)
@@ -105,75 +201,79 @@ func (st *CMD) GetParamByID(id string) (CMDParam, error) {
 
 // GetParamByIndex returns a CMDParam given its index
 func (st *CMD) GetParamByIndex(index int) (CMDParam, error) {
-	if index >= len(st.Params) {
+	if index < 0 || index >= len(st.Params) {
 		return CMDParam{}, fmt.Errorf("Index out of bounds: %v", index)
 	}
 
 	return st.Params[index], nil
 }
 
-// convertParamsToMap converts Params ([]CMDParam) to a map[string]CMDParam
-func (st *CMD) convertParamsToMap() {
-	st.mpParams = make(map[string]CMDParam)
-	for i := 0; i < len(st.Params); i++ {
-		st.mpParams[st.Params[i].ID] = st.Params[i]
+// CanExecute returns true whether the CMD can be executed. It verifies that the CMD meets all restrictions.
+func (st *CMD) CanExecute(inputMetadata botio.Metadata, generalMetadata botio.Metadata) (bool, string) {
+	result := st.CheckRestrictions(inputMetadata, generalMetadata)
+
+	return result.OK, result.Message
+}
+
+// CheckRestrictions evaluates the subtree restrictions (inherited ones included), GeneralRestrictions (all of them
+// must be met) AND the Restrictions expression. The result reports which branch failed.
+func (st *CMD) CheckRestrictions(inputMetadata botio.Metadata, generalMetadata botio.Metadata) RestrictionResult {
+	return st.getRestrictionExpr(true).Evaluate(inputMetadata, generalMetadata)
+}
+
+// getRestrictionExpr returns the expression combining all restrictions, subtree ones included only if requested
+func (st *CMD) getRestrictionExpr(includeSubtree bool) RestrictionExpr {
+	children := make([]RestrictionExpr, 0, len(st.subtreeRestrictions)+len(st.GeneralRestrictions)+1)
+	// subtree restrictions
+	if includeSubtree {
+		for _, restriction := range st.getSubtreeRestrictions() {
+			children = append(children, RestrictionLeaf(restriction))
+		}
+	}
+	// general restrictions
+	for i := 0; i < len(st.GeneralRestrictions); i++ {
+		children = append(children, legacyRestrictionLeaf(st.GeneralRestrictions[i]))
+	}
+	// restrictions' expression
+	if st.Restrictions != nil {
+		children = append(children, *st.Restrictions)
 	}
 
-	st.params2map = true
+	return RestrictionAnd(children...)
 }
 
-// CanExecute returns true whether the CMD can be executed. It verifies that the CMD meets all restrictions.
-func (st *CMD) CanExecute(inputMetadata botio.Metadata, generalMetadata botio.Metadata) (bool, string) {
-	// check general restrictions
-	if isOK, message := st.canExecuteByRestrictions(st.GeneralRestrictions, generalMetadata); !isOK {
-		return isOK, message
+// getSubtreeRestrictions returns the subtree restrictions, inherited ones included once the CMD was added to a
+// CMDManager
+func (st *CMD) getSubtreeRestrictions() []Restriction {
+	if st.subtreeRestrictions != nil {
+		return st.subtreeRestrictions
 	}
 
-	return true, ""
+	return st.SubtreeRestrictions
 }
 
-// canExecuteByRestrictions returns true whether the cmd can be executed because it meets all restrictions
-func (st *CMD) canExecuteByRestrictions(restrictions []Restriction, metadata botio.Metadata) (bool, string) {
-	for i := 0; i < len(restrictions); i++ {
-		fieldValue, fieldExists := metadata[restrictions[i].Field]
-		if !fieldExists {
-			return false, fmt.Sprintf("Missing metadata field: '%v'. Restriction '%v' cannot be checked.", restrictions[i].Field, restrictions[i].ID)
+// inheritSubtreeRestrictions sets the subtree restrictions: the inherited ones (overridden by ID) plus the own ones
+func (st *CMD) inheritSubtreeRestrictions(inherited []Restriction) {
+	ret := make([]Restriction, 0, len(inherited)+len(st.SubtreeRestrictions))
+	for i := 0; i < len(inherited); i++ {
+		if inherited[i].ID != "" && st.hasSubtreeRestriction(inherited[i].ID) {
+			// overridden
+			continue
 		}
+		ret = append(ret, inherited[i])
+	}
+	st.subtreeRestrictions = append(ret, st.SubtreeRestrictions...)
+}
 
-		switch restrictions[i].Concept {
-		// metadata value must be equal to one of the provided values (restrictions[i].Data)
-		case CMDRestrictionConceptInclude:
-			includeOK := true
-			for c := 0; c < len(restrictions[i].Data); c++ {
-				if restrictions[i].Data[c] == fieldValue {
-					includeOK = true
-					break
-				} else {
-					includeOK = false
-				}
-			}
-
-			if !includeOK {
-				return false, fmt.Sprintf("Metadata['%v'] does not meet restriction '%v' with concept '%v'.\nCurrent value: '%v'", restrictions[i].Field, restrictions[i].ID, restrictions[i].Concept, fieldValue)
-			}
-
-		// metadata value must not be equal to any of the provided values (restrictions[i].Data)
-		case CMDRestrictionConceptExclude:
-			excludeOK := true
-			for c := 0; c < len(restrictions[i].Data); c++ {
-				if restrictions[i].Data[c] == fieldValue {
-					excludeOK = false
-					break
-				}
-			}
-
-			if !excludeOK {
-				return false, fmt.Sprintf("Metadata['%v'] does not meet restriction '%v' with concept '%v'.\nCurrent value: '%v'", restrictions[i].Field, restrictions[i].ID, restrictions[i].Concept, fieldValue)
-			}
+// hasSubtreeRestriction returns true whether the CMD declares a subtree restriction having the given ID
+func (st *CMD) hasSubtreeRestriction(id string) bool {
+	for i := 0; i < len(st.SubtreeRestrictions); i++ {
+		if st.SubtreeRestrictions[i].ID == id {
+			return true
 		}
 	}
 
-	return true, ""
+	return false
 }
 
 // ***********************************************************************************************
@@ -183,6 +283,25 @@ func (st *CMD) canExecuteByRestrictions(restrictions []Restriction, metadata bot
 const (
 	CMDParamTypeInt    = "int"
 	CMDParamTypeString = "string"
+	CMDParamTypeFloat  = "float"
+	CMDParamTypeBool   = "bool"
+	// time.ParseDuration format: 90s, 5m, 1h30m
+	CMDParamTypeDuration = "duration"
+	// 2006-01-02
+	CMDParamTypeDate = "date"
+	// 15:04
+	CMDParamTypeTime = "time"
+	// RFC3339: 2006-01-02T15:04:05Z07:00
+	CMDParamTypeDateTime = "datetime"
+	// one of CMDParam.AllowedValues
+	CMDParamTypeEnum  = "enum"
+	CMDParamTypeURL   = "url"
+	CMDParamTypeEmail = "email"
+	// string matching CMDParam.Pattern
+	CMDParamTypeRegex = "regex"
+
+	paramDateLayout = "2006-01-02"
+	paramTimeLayout = "15:04"
 )
 
 type CMDParam struct {
@@ -191,24 +310,47 @@ type CMDParam struct {
 	Type        string
 	Required    bool
 	Value       string
+	// extra names to bind the param by name (--alias=value, alias=value, -alias). ID is always a valid name.
+	Aliases []string
+	// boolean switch: --id / -id sets the value to "true", "false" if not present
+	IsSwitch bool
+	// value to use when the param is not provided
+	Default string
+	// allowed values for CMDParamTypeEnum
+	AllowedValues []string
+	// regex the value must match for CMDParamTypeRegex
+	Pattern string
 }
 
 // isExpectedType verifies whether the param's type is the expected.
 func (st *CMDParam) isExpectedType() bool {
+	if st.Type == "" {
+		return true
+	}
+
+	validator, ok := getParamType(st.Type)
+	if !ok {
+		// unknown type
+		return false
+	}
+
+	return validator(*st) == nil
+}
+
+// GetTypeDescription returns the param's type along with its constraints (allowed values, pattern)
+func (st *CMDParam) GetTypeDescription() string {
 	switch st.Type {
 	case "":
-		return true
+		return CMDParamTypeString
 
-	case CMDParamTypeString:
-		// any type could be converted to string
-		return true
+	case CMDParamTypeEnum:
+		return fmt.Sprintf("%v (%v)", st.Type, strings.Join(st.AllowedValues, " | "))
 
-	case CMDParamTypeInt:
-		_, err := st.GetIntValue()
-		return err == nil
+	case CMDParamTypeRegex:
+		return fmt.Sprintf("%v (%v)", st.Type, st.Pattern)
 	}
 
-	return false
+	return st.Type
 }
 
 // GetIntValue returns the int value
@@ -216,6 +358,45 @@ func (st *CMDParam) GetIntValue() (int, error) {
 	return strconv.Atoi(st.Value)
 }
 
+// GetFloatValue returns the float value
+func (st *CMDParam) GetFloatValue() (float64, error) {
+	return strconv.ParseFloat(st.Value, 64)
+}
+
+// GetBoolValue returns the bool value (1, t, true, yes, y, on / 0, f, false, no, n, off)
+func (st *CMDParam) GetBoolValue() (bool, error) {
+	switch strings.ToLower(st.Value) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+
+	return strconv.ParseBool(st.Value)
+}
+
+// GetDurationValue returns the time.Duration value
+func (st *CMDParam) GetDurationValue() (time.Duration, error) {
+	return time.ParseDuration(st.Value)
+}
+
+// GetTimeValue returns the time.Time value for date, time and datetime params
+func (st *CMDParam) GetTimeValue() (time.Time, error) {
+	switch st.Type {
+	case CMDParamTypeDate:
+		return time.Parse(paramDateLayout, st.Value)
+	case CMDParamTypeTime:
+		return time.Parse(paramTimeLayout, st.Value)
+	}
+
+	return time.Parse(time.RFC3339, st.Value)
+}
+
+// GetURLValue returns the *url.URL value
+func (st *CMDParam) GetURLValue() (*url.URL, error) {
+	return url.ParseRequestURI(st.Value)
+}
+
 // ***********************************************************************************************
 // **  CMDError  *********************************************************************************
 // ***********************************************************************************************
@@ -227,6 +408,10 @@ type CMDError struct {
 
 const (
 	CMDErrorTypeNoCommand = "noCommand"
+	CMDErrorTypeTimeout   = "timeout"
+	CMDErrorTypePanic     = "panic"
+	CMDErrorTypeHandler   = "handler"
+	CMDErrorTypeConflict  = "conflict"
 )
 
 func NewCMDError(errorType string, errorMessage string) *CMDError {
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goagent/message"
+)
+
+const (
+	// MaxConcurrency / Cooldown are shared by all the requests (default)
+	CMDConcurrencyScopeGlobal = "global"
+	// MaxConcurrency / Cooldown are applied per channel (GeneralMetadataFieldWhere)
+	CMDConcurrencyScopeWhere = "where"
+
+	// the command is busy because it is running MaxConcurrency times
+	CMDBusyReasonRunning = "running"
+	// the command is busy because it ran less than Cooldown ago
+	CMDBusyReasonCooldown = "cooldown"
+
+	defaultBusyRunningMessage  = "Sorry, '%v' is already running, started by %v at %v."
+	defaultBusyCooldownMessage = "Sorry, '%v' was started by %v at %v. It can't run again until %v."
+)
+
+// cmdExecution is a running (or the last started) execution of a command
+type cmdExecution struct {
+	startedBy string
+	startedAt time.Time
+}
+
+// cmdExecutions holds the executions of a command within a scope (global or channel)
+type cmdExecutions struct {
+	running   []*cmdExecution
+	lastStart *cmdExecution
+}
+
+// executionTracker keeps the commands' executions to enforce MaxConcurrency and Cooldown
+type executionTracker struct {
+	executions map[string]*cmdExecutions
+	mutex      sync.Mutex
+}
+
+// busyInfo describes why a command can't be executed now
+type busyInfo struct {
+	reason    string
+	execution cmdExecution
+	// when the command could run again (cooldown only)
+	availableAt time.Time
+}
+
+// start registers an execution of the command. Returns a func to call once the execution finishes, or the busy
+// info if the command can't be executed now.
+func (st *executionTracker) start(cmd CMD, entry botio.InputEntry) (func(), *busyInfo) {
+	key := getExecutionKey(cmd, entry)
+	now := time.Now()
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.executions == nil {
+		st.executions = make(map[string]*cmdExecutions)
+	}
+	executions, ok := st.executions[key]
+	if !ok {
+		executions = &cmdExecutions{}
+		st.executions[key] = executions
+	}
+
+	if cmd.MaxConcurrency > 0 && len(executions.running) >= cmd.MaxConcurrency {
+		return nil, &busyInfo{
+			reason:    CMDBusyReasonRunning,
+			execution: *executions.running[0],
+		}
+	}
+
+	if cmd.Cooldown > 0 && executions.lastStart != nil && now.Sub(executions.lastStart.startedAt) < cmd.Cooldown {
+		return nil, &busyInfo{
+			reason:      CMDBusyReasonCooldown,
+			execution:   *executions.lastStart,
+			availableAt: executions.lastStart.startedAt.Add(cmd.Cooldown),
+		}
+	}
+
+	execution := &cmdExecution{
+		startedBy: getRequester(entry),
+		startedAt: now,
+	}
+	executions.running = append(executions.running, execution)
+	executions.lastStart = execution
+
+	return func() {
+		st.finish(key, cmd, execution)
+	}, nil
+}
+
+// finish removes the execution from the running ones
+func (st *executionTracker) finish(key string, cmd CMD, execution *cmdExecution) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	executions, ok := st.executions[key]
+	if !ok {
+		return
+	}
+
+	for i := 0; i < len(executions.running); i++ {
+		if executions.running[i] == execution {
+			executions.running = append(executions.running[:i:i], executions.running[i+1:]...)
+			break
+		}
+	}
+
+	// nothing else to remember
+	if len(executions.running) == 0 && (cmd.Cooldown <= 0 || time.Since(executions.lastStart.startedAt) >= cmd.Cooldown) {
+		delete(st.executions, key)
+	}
+}
+
+// limitExecutions returns a handler enforcing the CMD's MaxConcurrency / Cooldown. The execution is registered once the
+// returned handler is invoked (after the middlewares) and finishes once the handler returns (or panics), even if it
+// exceeded the timeout. Busy requests go to HandlerBusyV2 or get the default busy message.
+func (st *CMDManager) limitExecutions(handler CMDHandlerV2) CMDHandlerV2 {
+	return func(request CMDRequest) error {
+		finish, busy := st.executions.start(request.CMD, request.Entry)
+		if busy != nil {
+			request.HandlerType = CMDHandlerTypeBusy
+			request.Metadata = getBusyExtraData(busy)
+			busyHandler := request.CMD.getHandler(CMDHandlerTypeBusy)
+			if busyHandler == nil {
+				sendBusyMessage(request, busy)
+				return nil
+			}
+
+			return busyHandler(request)
+		}
+		defer finish()
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(request)
+	}
+}
+
+// hasExecutionLimits returns true whether the CMD declares MaxConcurrency or Cooldown
+func (st *CMD) hasExecutionLimits() bool {
+	return st.MaxConcurrency > 0 || st.Cooldown > 0
+}
+
+// getExecutionKey returns the key to track the command's executions within its concurrency scope
+func getExecutionKey(cmd CMD, entry botio.InputEntry) string {
+	if cmd.ConcurrencyScope == CMDConcurrencyScopeWhere {
+		return fmt.Sprintf("%v\x00%v:%v", cmd.path, entry.Origin, entry.GeneralMetadata[botio.GeneralMetadataFieldWhere])
+	}
+
+	return cmd.path
+}
+
+// getRequester returns the name (or ID) of the user who sent the entry
+func getRequester(entry botio.InputEntry) string {
+	if username, ok := entry.GeneralMetadata[botio.GeneralMetadataFieldUsername]; ok && fmt.Sprint(username) != "" {
+		return fmt.Sprint(username)
+	}
+	if userID, ok := entry.GeneralMetadata[botio.GeneralMetadataFieldUserID]; ok {
+		return fmt.Sprint(userID)
+	}
+
+	return "unknown"
+}
+
+// getUserID returns the ID of the user who sent the entry, false if it is missing or empty
+func getUserID(entry botio.InputEntry) (string, bool) {
+	userID, ok := entry.GeneralMetadata[botio.GeneralMetadataFieldUserID]
+	if !ok || userID == nil || fmt.Sprint(userID) == "" {
+		return "", false
+	}
+
+	return fmt.Sprint(userID), true
+}
+
+// getBusyExtraData returns the extra data for the busy handler
+func getBusyExtraData(busy *busyInfo) map[string]interface{} {
+	ret := map[string]interface{}{
+		CMDExtraDataBusyReason:    busy.reason,
+		CMDExtraDataBusyStartedBy: busy.execution.startedBy,
+		CMDExtraDataBusyStartedAt: busy.execution.startedAt,
+	}
+	if !busy.availableAt.IsZero() {
+		ret[CMDExtraDataBusyAvailableAt] = busy.availableAt
+	}
+
+	return ret
+}
+
+// sendBusyMessage replies the default busy message (commands without HandlerBusyV2)
+func sendBusyMessage(request CMDRequest, busy *busyInfo) {
+	text := fmt.Sprintf(defaultBusyRunningMessage, request.Pattern, busy.execution.startedBy, busy.execution.startedAt.Format(time.RFC3339))
+	if busy.reason == CMDBusyReasonCooldown {
+		text = fmt.Sprintf(defaultBusyCooldownMessage, request.Pattern, busy.execution.startedBy, busy.execution.startedAt.Format(time.RFC3339), busy.availableAt.Format(time.RFC3339))
+	}
+
+	message.SendMessageToOutput(text, request.Entry.InputMetadata, nil, request.Outputs)
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goagent/message"
+)
+
+const (
+	// default time to confirm a command
+	defaultConfirmationTimeout = 60 * time.Second
+	// replies to confirm / cancel a pending command: yes <token> / no <token>
+	confirmationYes = "yes"
+	confirmationNo  = "no"
+
+	defaultConfirmationPrompt      = "'%v' needs confirmation"
+	confirmationPromptMessage      = "%v, reply `%v %v` within %v"
+	confirmationExpiredMessage     = "Sorry, the confirmation for '%v' expired."
+	confirmationCancelledMessage   = "'%v' was cancelled."
+	confirmationNoUserMessage      = "Sorry, '%v' needs confirmation but your user can't be identified."
+	confirmationCommandGoneMessage = "'%v' was cancelled, the command was removed or replaced."
+)
+
+// pendingAction is a request waiting for the user to confirm it
+type pendingAction struct {
+	token     string
+	request   CMDRequest
+	expiresAt time.Time
+}
+
+// pendingActions keeps the requests waiting for confirmation, by user and conversation (origin, where, thread)
+type pendingActions struct {
+	actions map[string]*pendingAction
+	mutex   sync.Mutex
+}
+
+// add adds a pending action, replacing the conversation's previous one (if any)
+func (st *pendingActions) add(key string, action *pendingAction) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.actions == nil {
+		st.actions = make(map[string]*pendingAction)
+	}
+
+	// remove the expired ones
+	now := time.Now()
+	for k, a := range st.actions {
+		if now.After(a.expiresAt) {
+			delete(st.actions, k)
+		}
+	}
+
+	st.actions[key] = action
+}
+
+// get returns the conversation's pending action. Expired actions are removed and ignored.
+func (st *pendingActions) get(key string) (*pendingAction, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	action, ok := st.actions[key]
+	if ok && time.Now().After(action.expiresAt) {
+		// expired: nothing left to confirm
+		delete(st.actions, key)
+		return nil, false
+	}
+
+	return action, ok
+}
+
+// remove removes the conversation's pending action if it is still the given one. Returns false if it was already
+// removed (i.e. confirmed by a concurrent reply).
+func (st *pendingActions) remove(key string, action *pendingAction) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if current, ok := st.actions[key]; !ok || current != action {
+		return false
+	}
+	delete(st.actions, key)
+
+	return true
+}
+
+// removeByRootID removes the pending actions for the top level command having the given ID
+func (st *pendingActions) removeByRootID(rootID string) []*pendingAction {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	ret := make([]*pendingAction, 0)
+	for key, action := range st.actions {
+		if action.request.CMD.rootID == rootID {
+			ret = append(ret, action)
+			delete(st.actions, key)
+		}
+	}
+
+	return ret
+}
+
+// requestConfirmation saves the request as pending and asks the user to confirm it. Requests from unidentified users
+// (no GeneralMetadataFieldUserID) are refused, they can't be told apart from other users' confirmations.
+func (st *CMDManager) requestConfirmation(request CMDRequest) {
+	if _, ok := getUserID(request.Entry); !ok {
+		message.SendMessageToOutput(fmt.Sprintf(confirmationNoUserMessage, request.Pattern), request.Entry.InputMetadata, nil, request.Outputs)
+		return
+	}
+
+	timeout := request.CMD.ConfirmationTimeout
+	if timeout <= 0 {
+		timeout = defaultConfirmationTimeout
+	}
+
+	action := &pendingAction{
+		token:     getConfirmationToken(),
+		request:   request,
+		expiresAt: time.Now().Add(timeout),
+	}
+	st.pendingConfirmations.add(getConversationKey(request.Entry), action)
+
+	prompt := request.CMD.ConfirmationMessage
+	if prompt == "" {
+		prompt = fmt.Sprintf(defaultConfirmationPrompt, request.Pattern)
+	}
+	message.SendMessageToOutput(
+		fmt.Sprintf(confirmationPromptMessage, prompt, confirmationYes, action.token, formatDuration(timeout)),
+		request.Entry.InputMetadata,
+		nil,
+		request.Outputs,
+	)
+}
+
+// processConfirmation handles the confirmation replies (yes <token> / no <token>) for the conversation's pending
+// request. Returns true whether the entry was a confirmation reply, along with the confirmed handler's error.
+func (st *CMDManager) processConfirmation(ctx context.Context, entry botio.InputEntry, outputs []botio.Output) (bool, error) {
+	words := getTokenValues(tokenize(entry.Query))
+	if len(words) != 2 {
+		return false, nil
+	}
+	answer := strings.ToLower(words[0])
+	if answer != confirmationYes && answer != confirmationNo {
+		return false, nil
+	}
+
+	// only identified users have pending requests
+	if _, ok := getUserID(entry); !ok {
+		return false, nil
+	}
+
+	key := getConversationKey(entry)
+	action, ok := st.pendingConfirmations.get(key)
+	if !ok {
+		return false, nil
+	}
+
+	// not a confirmation reply (i.e. "no worries"): the entry goes on to the regular matching
+	if words[1] != action.token {
+		return false, nil
+	}
+
+	if !st.pendingConfirmations.remove(key, action) {
+		// already confirmed / cancelled
+		return true, nil
+	}
+
+	if time.Now().After(action.expiresAt) {
+		message.SendMessageToOutput(fmt.Sprintf(confirmationExpiredMessage, action.request.Pattern), entry.InputMetadata, nil, outputs)
+		return true, nil
+	}
+
+	if answer == confirmationNo {
+		message.SendMessageToOutput(fmt.Sprintf(confirmationCancelledMessage, action.request.Pattern), entry.InputMetadata, nil, outputs)
+		return true, nil
+	}
+
+	// run the confirmed request, replies go to the confirmation's outputs
+	request := action.request
+	request.Context = ctx
+	request.Outputs = outputs
+
+	return true, st.dispatchOrRequestApproval(request)
+}
+
+// getConversationKey returns the key for the user within the conversation (origin, where, thread)
+func getConversationKey(entry botio.InputEntry) string {
+	return fmt.Sprintf(
+		"%v\x00%v\x00%v\x00%v",
+		entry.Origin,
+		entry.GeneralMetadata[botio.GeneralMetadataFieldUserID],
+		entry.GeneralMetadata[botio.GeneralMetadataFieldWhere],
+		entry.GeneralMetadata[botio.GeneralMetadataFieldThread],
+	)
+}
+
+// getConfirmationToken returns a random token
+func getConfirmationToken() string {
+	data := make([]byte, 3)
+	if _, err := rand.Read(data); err != nil {
+		// the time is good enough to tell the confirmations apart
+		return fmt.Sprintf("%x", time.Now().UnixNano()&0xffffff)
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// formatDuration returns the duration in a short form: 60s, 5m, 1h30m
+func formatDuration(duration time.Duration) string {
+	if duration <= time.Minute {
+		return fmt.Sprintf("%vs", int(duration.Seconds()))
+	}
+
+	ret := duration.Round(time.Second).String()
+	if strings.HasSuffix(ret, "m0s") {
+		ret = strings.TrimSuffix(ret, "0s")
+	}
+	if strings.HasSuffix(ret, "h0m") {
+		ret = strings.TrimSuffix(ret, "0m")
+	}
+
+	return ret
+}
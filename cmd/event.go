@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+// ErrorEvent is a structured error published into the CMDManager's error channel
+type ErrorEvent struct {
+	// error type (CMDErrorType*)
+	Type string
+	// original error
+	Err error
+	// entry being processed
+	Entry botio.InputEntry
+	// pattern that matched (if any)
+	Pattern string
+	// handler type being executed (if any)
+	HandlerType string
+	// stack trace (only for panics)
+	Stack []byte
+	Time  time.Time
+}
+
+func NewErrorEvent(errorType string, err error, entry botio.InputEntry) ErrorEvent {
+	return ErrorEvent{
+		Type:  errorType,
+		Err:   err,
+		Entry: entry,
+		Time:  time.Now(),
+	}
+}
+
+func (st ErrorEvent) Error() string {
+	if st.Err == nil {
+		return fmt.Sprintf("%v error", st.Type)
+	}
+
+	return st.Err.Error()
+}
+
+// Unwrap returns the original error
+func (st ErrorEvent) Unwrap() error {
+	return st.Err
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+// CMDRequest is the data a CMDHandlerV2 gets
+type CMDRequest struct {
+	// context to cancel / time-limit the handler
+	Context context.Context
+	// CMD that matched
+	CMD CMD
+	// exact CMD pattern that matched
+	Pattern string
+	// content to parse (original content - cmd)
+	Content string
+	// extra data related to the CMD
+	Metadata botio.Metadata
+	// indicates which handler type will be used (parameters: ok, missing, extra, ...)
+	HandlerType string
+	// entry exactly as it comes from the input (origin, query, input metadata and general metadata)
+	Entry botio.InputEntry
+	// outputs to send the replies
+	Outputs []botio.Output
+}
+
+// GetParam returns the param bound for this request given its ID
+func (st CMDRequest) GetParam(id string) (CMDParam, error) {
+	return st.CMD.GetParamByID(id)
+}
+
+// CMDHandlerV2 is the function handler. Returned errors are reported by CMDManager.ProcessContext.
+type CMDHandlerV2 func(request CMDRequest) error
+
+// AdaptCMDHandler wraps a CMDHandler into a CMDHandlerV2
+func AdaptCMDHandler(handler CMDHandler) CMDHandlerV2 {
+	if handler == nil {
+		return nil
+	}
+
+	return func(request CMDRequest) error {
+		handler(
+			request.CMD,
+			request.Pattern,
+			request.Content,
+			request.Metadata,
+			request.HandlerType,
+			request.Entry.InputMetadata,
+			request.Entry.GeneralMetadata,
+			request.Outputs,
+		)
+
+		return nil
+	}
+}
+
+// getHandler returns the handler for the given handler type. A CMDHandlerV2 has priority over a CMDHandler.
+// Returns nil if there is no handler.
+func (st *CMD) getHandler(handlerType string) CMDHandlerV2 {
+	var (
+		handler   CMDHandler
+		handlerV2 CMDHandlerV2
+	)
+
+	switch handlerType {
+	case CMDHandlerTypeDefault:
+		handler, handlerV2 = st.Handler, st.HandlerV2
+	case CMDHandlerTypeError:
+		handler, handlerV2 = st.HandlerError, st.HandlerErrorV2
+	case CMDHandlerTypeRestrictions:
+		handler, handlerV2 = st.HandlerRestrictions, st.HandlerRestrictionsV2
+	case CMDHandlerTypeParams:
+		handler, handlerV2 = st.HandlerParams, st.HandlerParamsV2
+	case CMDHandlerTypeParamsWrongType:
+		handler, handlerV2 = st.HandlerParamsWrongType, st.HandlerParamsWrongTypeV2
+	case CMDHandlerTypeParamsMissing:
+		handler, handlerV2 = st.HandlerParamsMissing, st.HandlerParamsMissingV2
+	case CMDHandlerTypeParamsExtra:
+		handler, handlerV2 = st.HandlerParamsExtra, st.HandlerParamsExtraV2
+	case CMDHandlerTypeBusy:
+		handlerV2 = st.HandlerBusyV2
+	}
+
+	if handlerV2 != nil {
+		return handlerV2
+	}
+
+	return AdaptCMDHandler(handler)
+}
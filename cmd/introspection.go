@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CMDInfo is a read-only description of a command
+type CMDInfo struct {
+	ID string `json:"id,omitempty"`
+	// first pattern of each ancestor and the command's first pattern
+	Path        []string `json:"path"`
+	PatternType string   `json:"patternType"`
+	Pattern     string   `json:"pattern"`
+	// patterns but the first one
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Priority    int      `json:"priority,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+	// executions' limits
+	MaxConcurrency   int    `json:"maxConcurrency,omitempty"`
+	ConcurrencyScope string `json:"concurrencyScope,omitempty"`
+	Cooldown         string `json:"cooldown,omitempty"`
+	// confirmation
+	RequireConfirmation bool   `json:"requireConfirmation,omitempty"`
+	ConfirmationMessage string `json:"confirmationMessage,omitempty"`
+	// two-person approval
+	ApproverRoles   []string `json:"approverRoles,omitempty"`
+	ApprovalTimeout string   `json:"approvalTimeout,omitempty"`
+	// the user needs any of these roles
+	RequiredRoles []string          `json:"requiredRoles,omitempty"`
+	Params        []CMDParamInfo    `json:"params,omitempty"`
+	Restrictions  []RestrictionInfo `json:"restrictions,omitempty"`
+	// readable form of the restrictions' expression, its leaves are listed in Restrictions
+	RestrictionExpression string    `json:"restrictionExpression,omitempty"`
+	SubCommands           []CMDInfo `json:"subCommands,omitempty"`
+}
+
+// CMDParamInfo is a read-only description of a command's param
+type CMDParamInfo struct {
+	ID            string   `json:"id"`
+	Description   string   `json:"description,omitempty"`
+	Type          string   `json:"type"`
+	Required      bool     `json:"required"`
+	Aliases       []string `json:"aliases,omitempty"`
+	IsSwitch      bool     `json:"isSwitch,omitempty"`
+	Default       string   `json:"default,omitempty"`
+	AllowedValues []string `json:"allowedValues,omitempty"`
+	Pattern       string   `json:"pattern,omitempty"`
+}
+
+// RestrictionInfo is a read-only description of a command's restriction
+type RestrictionInfo struct {
+	ID      string        `json:"id"`
+	Concept string        `json:"concept"`
+	Field   string        `json:"field"`
+	Data    []interface{} `json:"data,omitempty"`
+	Source  string        `json:"source,omitempty"`
+	// subtree restriction (declared by the command or inherited)
+	Subtree bool `json:"subtree,omitempty"`
+}
+
+// GetCMDTree returns the description of all commands (in matching order) and their subcommands
+func (st *CMDManager) GetCMDTree() []CMDInfo {
+	commands := st.getCommands()
+
+	ret := make([]CMDInfo, 0, len(commands))
+	for i := 0; i < len(commands); i++ {
+		ret = append(ret, getCMDInfo(commands[i], []string{}))
+	}
+
+	return ret
+}
+
+// Walk invokes fn for each command in the tree (depth-first, parents first). It stops at the first error.
+func (st *CMDManager) Walk(fn func(info CMDInfo) error) error {
+	return walkCMDInfo(st.GetCMDTree(), fn)
+}
+
+// ExportJSON exports the commands' tree as JSON
+func (st *CMDManager) ExportJSON() ([]byte, error) {
+	return json.MarshalIndent(st.GetCMDTree(), "", "  ")
+}
+
+// ExportMarkdown exports the commands' tree as a Markdown reference
+func (st *CMDManager) ExportMarkdown() string {
+	var builder strings.Builder
+	builder.WriteString("# Commands\n")
+
+	st.Walk(func(info CMDInfo) error {
+		// heading level by depth
+		builder.WriteString(fmt.Sprintf("\n%v `%v`\n\n", strings.Repeat("#", minInt(len(info.Path)+1, 6)), strings.Join(info.Path, " ")))
+
+		if info.Description != "" {
+			builder.WriteString(fmt.Sprintf("%v\n\n", info.Description))
+		}
+		builder.WriteString(fmt.Sprintf("- Pattern type: %v\n", info.PatternType))
+		if len(info.Aliases) > 0 {
+			builder.WriteString(fmt.Sprintf("- Aliases: `%v`\n", strings.Join(info.Aliases, "`, `")))
+		}
+		if info.ID != "" {
+			builder.WriteString(fmt.Sprintf("- ID: %v\n", info.ID))
+		}
+		if info.Priority != 0 {
+			builder.WriteString(fmt.Sprintf("- Priority: %v\n", info.Priority))
+		}
+		if info.Timeout != "" {
+			builder.WriteString(fmt.Sprintf("- Timeout: %v\n", info.Timeout))
+		}
+		if info.MaxConcurrency > 0 {
+			builder.WriteString(fmt.Sprintf("- Max concurrency: %v\n", info.MaxConcurrency))
+		}
+		if info.Cooldown != "" {
+			builder.WriteString(fmt.Sprintf("- Cooldown: %v\n", info.Cooldown))
+		}
+		if info.ConcurrencyScope != "" {
+			builder.WriteString(fmt.Sprintf("- Concurrency scope: %v\n", info.ConcurrencyScope))
+		}
+		if info.RequireConfirmation {
+			builder.WriteString("- Requires confirmation\n")
+		}
+		if len(info.ApproverRoles) > 0 {
+			builder.WriteString(fmt.Sprintf("- Requires approval by (any): %v\n", strings.Join(info.ApproverRoles, ", ")))
+		}
+		if len(info.RequiredRoles) > 0 {
+			builder.WriteString(fmt.Sprintf("- Required roles (any): %v\n", strings.Join(info.RequiredRoles, ", ")))
+		}
+
+		if len(info.Params) > 0 {
+			builder.WriteString("\n| Param | Type | Required | Default | Description |\n|---|---|---|---|---|\n")
+			for _, param := range info.Params {
+				names := "`--" + param.ID + "`"
+				for _, alias := range param.Aliases {
+					names += ", `--" + alias + "`"
+				}
+
+				builder.WriteString(fmt.Sprintf("| %v | %v | %v | %v | %v |\n", names, escapeMarkdownCell(getParamInfoType(param)), param.Required, escapeMarkdownCell(param.Default), escapeMarkdownCell(param.Description)))
+			}
+		}
+
+		if len(info.Restrictions) > 0 {
+			builder.WriteString("\nRestrictions:\n\n")
+			if info.RestrictionExpression != "" {
+				builder.WriteString(fmt.Sprintf("- Expression: `%v`\n", info.RestrictionExpression))
+			}
+			for _, restriction := range info.Restrictions {
+				field := restriction.Field
+				if restriction.Source == CMDRestrictionSourceInput {
+					field = "input." + field
+				}
+				subtree := ""
+				if restriction.Subtree {
+					subtree = " (subtree)"
+				}
+				builder.WriteString(fmt.Sprintf("- %v: %v `%v` %v%v\n", restriction.ID, restriction.Concept, field, restriction.Data, subtree))
+			}
+		}
+
+		return nil
+	})
+
+	return builder.String()
+}
+
+// getCMDInfo returns the description for the given command and its subcommands
+func getCMDInfo(cmd CMD, parentPath []string) CMDInfo {
+	ret := CMDInfo{
+		ID:          cmd.ID,
+		Path:        append(append([]string{}, parentPath...), getFirstPattern(cmd)),
+		PatternType: cmd.PatternType,
+		Pattern:     getFirstPattern(cmd),
+		Description: cmd.Description,
+		Priority:    cmd.Priority,
+	}
+
+	if cmd.hasExecutionLimits() {
+		ret.MaxConcurrency = cmd.MaxConcurrency
+		ret.ConcurrencyScope = cmd.ConcurrencyScope
+		if ret.ConcurrencyScope == "" {
+			ret.ConcurrencyScope = CMDConcurrencyScopeGlobal
+		}
+	}
+	if cmd.Cooldown > 0 {
+		ret.Cooldown = cmd.Cooldown.String()
+	}
+	ret.RequireConfirmation = cmd.RequireConfirmation
+	ret.ConfirmationMessage = cmd.ConfirmationMessage
+	if len(cmd.ApproverRoles) > 0 {
+		ret.ApproverRoles = append([]string{}, cmd.ApproverRoles...)
+		approvalTimeout := cmd.ApprovalTimeout
+		if approvalTimeout <= 0 {
+			approvalTimeout = defaultApprovalTimeout
+		}
+		ret.ApprovalTimeout = approvalTimeout.String()
+	}
+	if len(cmd.RequiredRoles) > 0 {
+		ret.RequiredRoles = append([]string{}, cmd.RequiredRoles...)
+	}
+
+	if len(cmd.Pattern) > 1 {
+		ret.Aliases = append([]string{}, cmd.Pattern[1:]...)
+	}
+
+	if cmd.Timeout > 0 {
+		ret.Timeout = cmd.Timeout.String()
+	}
+
+	for _, param := range cmd.Params {
+		paramType := param.Type
+		if paramType == "" {
+			paramType = CMDParamTypeString
+		}
+
+		ret.Params = append(ret.Params, CMDParamInfo{
+			ID:            param.ID,
+			Description:   param.Description,
+			Type:          paramType,
+			Required:      param.Required,
+			Aliases:       append([]string{}, param.Aliases...),
+			IsSwitch:      param.IsSwitch,
+			Default:       param.Default,
+			AllowedValues: append([]string{}, param.AllowedValues...),
+			Pattern:       param.Pattern,
+		})
+	}
+
+	restrictions := append([]Restriction{}, cmd.GeneralRestrictions...)
+	if cmd.Restrictions != nil {
+		ret.RestrictionExpression = cmd.Restrictions.String()
+		restrictions = append(restrictions, cmd.Restrictions.getRestrictions()...)
+	}
+	subtreeRestrictions := cmd.getSubtreeRestrictions()
+	restrictions = append(append([]Restriction{}, subtreeRestrictions...), restrictions...)
+	for i, restriction := range restrictions {
+		ret.Restrictions = append(ret.Restrictions, RestrictionInfo{
+			ID:      restriction.ID,
+			Concept: restriction.Concept,
+			Field:   restriction.Field,
+			Data:    append([]interface{}{}, restriction.Data...),
+			Source:  restriction.Source,
+			Subtree: i < len(subtreeRestrictions),
+		})
+	}
+
+	for _, sub := range cmd.SubCommands {
+		ret.SubCommands = append(ret.SubCommands, getCMDInfo(sub, ret.Path))
+	}
+
+	return ret
+}
+
+// walkCMDInfo invokes fn for each command (depth-first, parents first)
+func walkCMDInfo(infos []CMDInfo, fn func(info CMDInfo) error) error {
+	for i := 0; i < len(infos); i++ {
+		if err := fn(infos[i]); err != nil {
+			return err
+		}
+
+		if err := walkCMDInfo(infos[i].SubCommands, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getParamInfoType returns the param's type for the Markdown export
+func getParamInfoType(param CMDParamInfo) string {
+	if param.IsSwitch {
+		return "switch"
+	}
+
+	return (&CMDParam{Type: param.Type, AllowedValues: param.AllowedValues, Pattern: param.Pattern}).GetTypeDescription()
+}
+
+// escapeMarkdownCell escapes the characters breaking a Markdown table's cell
+func escapeMarkdownCell(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
@@ -0,0 +1,438 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ***********************************************************************************************
+// **  HandlerRegistry  **************************************************************************
+// ***********************************************************************************************
+
+// HandlerRegistry binds handler names (used in YAML / JSON command files) to Go handlers
+type HandlerRegistry struct {
+	handlers map[string]CMDHandlerV2
+	mutex    sync.RWMutex
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	ret := &HandlerRegistry{}
+	ret.initialize()
+
+	return ret
+}
+
+func (st *HandlerRegistry) initialize() {
+	st.handlers = make(map[string]CMDHandlerV2)
+}
+
+// Register registers a handler by name
+func (st *HandlerRegistry) Register(name string, handler CMDHandlerV2) error {
+	if name == "" || handler == nil {
+		return fmt.Errorf("handler needs a name and a function")
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if _, ok := st.handlers[name]; ok {
+		return fmt.Errorf("handler '%v' already exists", name)
+	}
+	st.handlers[name] = handler
+
+	return nil
+}
+
+// RegisterCMDHandler registers a CMDHandler by name
+func (st *HandlerRegistry) RegisterCMDHandler(name string, handler CMDHandler) error {
+	return st.Register(name, AdaptCMDHandler(handler))
+}
+
+// Get returns the handler registered with the given name
+func (st *HandlerRegistry) Get(name string) (CMDHandlerV2, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	handler, ok := st.handlers[name]
+	return handler, ok
+}
+
+// ***********************************************************************************************
+// **  Definitions  ******************************************************************************
+// ***********************************************************************************************
+
+// CMDDefinition is the declarative (YAML / JSON) form of a CMD
+type CMDDefinition struct {
+	ID          string   `json:"id" yaml:"id"`
+	PatternType string   `json:"patternType" yaml:"patternType"`
+	Pattern     []string `json:"pattern" yaml:"pattern"`
+	Description string   `json:"description" yaml:"description"`
+	Priority    int      `json:"priority" yaml:"priority"`
+	// time.ParseDuration format: 30s, 5m
+	Timeout          string `json:"timeout" yaml:"timeout"`
+	TimeoutMessage   string `json:"timeoutMessage" yaml:"timeoutMessage"`
+	MaxConcurrency   int    `json:"maxConcurrency" yaml:"maxConcurrency"`
+	ConcurrencyScope string `json:"concurrencyScope" yaml:"concurrencyScope"`
+	// time.ParseDuration format: 30s, 5m
+	Cooldown string `json:"cooldown" yaml:"cooldown"`
+	// confirmation prompt (yes <token>) before running the handler
+	RequireConfirmation bool   `json:"requireConfirmation" yaml:"requireConfirmation"`
+	ConfirmationMessage string `json:"confirmationMessage" yaml:"confirmationMessage"`
+	// time.ParseDuration format: 30s, 5m
+	ConfirmationTimeout string `json:"confirmationTimeout" yaml:"confirmationTimeout"`
+	// two-person approval: any of these roles, but the requester
+	ApproverRoles []string `json:"approverRoles" yaml:"approverRoles"`
+	// time.ParseDuration format: 30m, 1h
+	ApprovalTimeout string                  `json:"approvalTimeout" yaml:"approvalTimeout"`
+	Handlers        CMDHandlersDefinition   `json:"handlers" yaml:"handlers"`
+	Params          []CMDParamDefinition    `json:"params" yaml:"params"`
+	Restrictions    []RestrictionDefinition `json:"restrictions" yaml:"restrictions"`
+	// restrictions' expression (and / or / not), evaluated along with Restrictions
+	RestrictionTree *RestrictionDefinition `json:"restrictionTree" yaml:"restrictionTree"`
+	// the user needs any of these roles (see CMDManager.SetRoleChecker)
+	RequiredRoles []string `json:"requiredRoles" yaml:"requiredRoles"`
+	// restrictions inherited by all subcommands (same ID overrides)
+	SubtreeRestrictions []RestrictionDefinition `json:"subtreeRestrictions" yaml:"subtreeRestrictions"`
+	SubCommands         []CMDDefinition         `json:"subCommands" yaml:"subCommands"`
+}
+
+// CMDHandlersDefinition holds the names of the handlers (registered in a HandlerRegistry)
+type CMDHandlersDefinition struct {
+	Default         string `json:"default" yaml:"default"`
+	Error           string `json:"error" yaml:"error"`
+	Restrictions    string `json:"restrictions" yaml:"restrictions"`
+	Params          string `json:"params" yaml:"params"`
+	ParamsWrongType string `json:"paramsWrongType" yaml:"paramsWrongType"`
+	ParamsMissing   string `json:"paramsMissing" yaml:"paramsMissing"`
+	ParamsExtra     string `json:"paramsExtra" yaml:"paramsExtra"`
+	Busy            string `json:"busy" yaml:"busy"`
+}
+
+// CMDParamDefinition is the declarative (YAML / JSON) form of a CMDParam
+type CMDParamDefinition struct {
+	ID            string   `json:"id" yaml:"id"`
+	Description   string   `json:"description" yaml:"description"`
+	Type          string   `json:"type" yaml:"type"`
+	Required      bool     `json:"required" yaml:"required"`
+	Aliases       []string `json:"aliases" yaml:"aliases"`
+	IsSwitch      bool     `json:"isSwitch" yaml:"isSwitch"`
+	Default       string   `json:"default" yaml:"default"`
+	AllowedValues []string `json:"allowedValues" yaml:"allowedValues"`
+	Pattern       string   `json:"pattern" yaml:"pattern"`
+}
+
+// RestrictionDefinition is the declarative (YAML / JSON) form of a Restriction. Within a RestrictionTree, nodes
+// having an Operator (and / or / not) combine their Children, the rest are leaves.
+type RestrictionDefinition struct {
+	ID      string        `json:"id" yaml:"id"`
+	Concept string        `json:"concept" yaml:"concept"`
+	Field   string        `json:"field" yaml:"field"`
+	Data    []interface{} `json:"data" yaml:"data"`
+	// general (default) / input
+	Source   string                  `json:"source" yaml:"source"`
+	Operator string                  `json:"operator" yaml:"operator"`
+	Children []RestrictionDefinition `json:"children" yaml:"children"`
+}
+
+// ***********************************************************************************************
+// **  Loader  ***********************************************************************************
+// ***********************************************************************************************
+
+// CMDLoadError holds all problems found while loading command definitions
+type CMDLoadError struct {
+	Problems []string
+	// handler names not found in the registry
+	UnresolvedHandlers []string
+}
+
+func (st *CMDLoadError) Error() string {
+	return fmt.Sprintf("invalid command definitions:\n%v", strings.Join(st.Problems, "\n"))
+}
+
+// addProblem adds a problem for the given command path
+func (st *CMDLoadError) addProblem(path []string, format string, args ...interface{}) {
+	st.Problems = append(st.Problems, fmt.Sprintf("%v: %v", strings.Join(path, " > "), fmt.Sprintf(format, args...)))
+}
+
+// LoadCMDsFromFile loads the commands from a YAML (.yaml, .yml) or JSON (.json) file
+func LoadCMDsFromFile(path string, registry *HandlerRegistry) ([]CMD, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadCMDsFromYAML(data, registry)
+	case ".json":
+		return LoadCMDsFromJSON(data, registry)
+	}
+
+	return nil, fmt.Errorf("unknown commands file format: '%v'", path)
+}
+
+// LoadCMDsFromJSON loads the commands from a JSON array of CMDDefinition
+func LoadCMDsFromJSON(data []byte, registry *HandlerRegistry) ([]CMD, error) {
+	definitions := make([]CMDDefinition, 0)
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, err
+	}
+
+	return LoadCMDs(definitions, registry)
+}
+
+// LoadCMDsFromYAML loads the commands from a YAML list of CMDDefinition
+func LoadCMDsFromYAML(data []byte, registry *HandlerRegistry) ([]CMD, error) {
+	definitions := make([]CMDDefinition, 0)
+	if err := yaml.Unmarshal(data, &definitions); err != nil {
+		return nil, err
+	}
+
+	return LoadCMDs(definitions, registry)
+}
+
+// LoadCMDs builds the commands from their definitions, binding the handlers by name. All definitions are validated,
+// a *CMDLoadError listing every problem (unresolved handlers included) is returned if something is wrong.
+func LoadCMDs(definitions []CMDDefinition, registry *HandlerRegistry) ([]CMD, error) {
+	if registry == nil {
+		registry = NewHandlerRegistry()
+	}
+
+	loadError := &CMDLoadError{}
+	ret := make([]CMD, 0, len(definitions))
+	for i := 0; i < len(definitions); i++ {
+		ret = append(ret, loadCMD(definitions[i], registry, []string{}, loadError))
+	}
+
+	if len(loadError.Problems) > 0 {
+		return nil, loadError
+	}
+
+	return ret, nil
+}
+
+// loadCMD builds a command from its definition, problems are added to loadError
+func loadCMD(definition CMDDefinition, registry *HandlerRegistry, parentPath []string, loadError *CMDLoadError) CMD {
+	name := definition.ID
+	if name == "" && len(definition.Pattern) > 0 {
+		name = definition.Pattern[0]
+	}
+	path := append(append([]string{}, parentPath...), name)
+
+	ret := CMD{
+		ID:             definition.ID,
+		PatternType:    definition.PatternType,
+		Pattern:        definition.Pattern,
+		Description:    definition.Description,
+		Priority:       definition.Priority,
+		TimeoutMessage: definition.TimeoutMessage,
+		RequiredRoles:  definition.RequiredRoles,
+		MaxConcurrency: definition.MaxConcurrency,
+		// confirmation
+		RequireConfirmation: definition.RequireConfirmation,
+		ConfirmationMessage: definition.ConfirmationMessage,
+		// approval
+		ApproverRoles: definition.ApproverRoles,
+	}
+
+	// patterns
+	if len(definition.Pattern) == 0 {
+		loadError.addProblem(path, "missing pattern")
+	}
+	switch definition.PatternType {
+	case CMDTypeWord:
+	case CMDTypeRegex:
+		for _, pattern := range definition.Pattern {
+			if _, err := regexp.Compile(pattern); err != nil {
+				loadError.addProblem(path, "invalid regex '%v': %v", pattern, err.Error())
+			}
+		}
+	default:
+		loadError.addProblem(path, "unknown pattern type '%v'", definition.PatternType)
+	}
+
+	// timeout
+	if definition.Timeout != "" {
+		timeout, err := time.ParseDuration(definition.Timeout)
+		if err != nil {
+			loadError.addProblem(path, "invalid timeout '%v'", definition.Timeout)
+		}
+		ret.Timeout = timeout
+	}
+
+	// concurrency
+	if definition.MaxConcurrency < 0 {
+		loadError.addProblem(path, "invalid maxConcurrency %v", definition.MaxConcurrency)
+	}
+	switch definition.ConcurrencyScope {
+	case "", CMDConcurrencyScopeGlobal, CMDConcurrencyScopeWhere:
+		ret.ConcurrencyScope = definition.ConcurrencyScope
+	default:
+		loadError.addProblem(path, "unknown concurrency scope '%v'", definition.ConcurrencyScope)
+	}
+	if definition.Cooldown != "" {
+		cooldown, err := time.ParseDuration(definition.Cooldown)
+		if err != nil {
+			loadError.addProblem(path, "invalid cooldown '%v'", definition.Cooldown)
+		}
+		ret.Cooldown = cooldown
+	}
+
+	// confirmation
+	if definition.ConfirmationTimeout != "" {
+		confirmationTimeout, err := time.ParseDuration(definition.ConfirmationTimeout)
+		if err != nil {
+			loadError.addProblem(path, "invalid confirmationTimeout '%v'", definition.ConfirmationTimeout)
+		}
+		ret.ConfirmationTimeout = confirmationTimeout
+	}
+
+	// approval
+	if definition.ApprovalTimeout != "" {
+		approvalTimeout, err := time.ParseDuration(definition.ApprovalTimeout)
+		if err != nil {
+			loadError.addProblem(path, "invalid approvalTimeout '%v'", definition.ApprovalTimeout)
+		}
+		ret.ApprovalTimeout = approvalTimeout
+	}
+
+	// handlers
+	handlers := []struct {
+		name    string
+		handler *CMDHandlerV2
+	}{
+		{definition.Handlers.Default, &ret.HandlerV2},
+		{definition.Handlers.Error, &ret.HandlerErrorV2},
+		{definition.Handlers.Restrictions, &ret.HandlerRestrictionsV2},
+		{definition.Handlers.Params, &ret.HandlerParamsV2},
+		{definition.Handlers.ParamsWrongType, &ret.HandlerParamsWrongTypeV2},
+		{definition.Handlers.ParamsMissing, &ret.HandlerParamsMissingV2},
+		{definition.Handlers.ParamsExtra, &ret.HandlerParamsExtraV2},
+		{definition.Handlers.Busy, &ret.HandlerBusyV2},
+	}
+	for _, h := range handlers {
+		if h.name == "" {
+			continue
+		}
+
+		handler, ok := registry.Get(h.name)
+		if !ok {
+			loadError.addProblem(path, "unresolved handler '%v'", h.name)
+			loadError.UnresolvedHandlers = append(loadError.UnresolvedHandlers, h.name)
+			continue
+		}
+		*h.handler = handler
+	}
+
+	// params
+	for _, paramDefinition := range definition.Params {
+		param := CMDParam{
+			ID:            paramDefinition.ID,
+			Description:   paramDefinition.Description,
+			Type:          paramDefinition.Type,
+			Required:      paramDefinition.Required,
+			Aliases:       paramDefinition.Aliases,
+			IsSwitch:      paramDefinition.IsSwitch,
+			Default:       paramDefinition.Default,
+			AllowedValues: paramDefinition.AllowedValues,
+			Pattern:       paramDefinition.Pattern,
+		}
+
+		if param.ID == "" {
+			loadError.addProblem(path, "param without id")
+		}
+		if err := validateParamDefinition(param); err != nil {
+			loadError.addProblem(path, "%v", err.Error())
+		}
+
+		ret.Params = append(ret.Params, param)
+	}
+
+	// restrictions
+	for _, restrictionDefinition := range definition.Restrictions {
+		if restrictionDefinition.Operator != "" {
+			loadError.addProblem(path, "restriction '%v': operators are only allowed in restrictionTree", restrictionDefinition.ID)
+			continue
+		}
+
+		ret.GeneralRestrictions = append(ret.GeneralRestrictions, loadRestriction(restrictionDefinition, path, loadError))
+	}
+	for _, restrictionDefinition := range definition.SubtreeRestrictions {
+		if restrictionDefinition.Operator != "" {
+			loadError.addProblem(path, "restriction '%v': operators are only allowed in restrictionTree", restrictionDefinition.ID)
+			continue
+		}
+
+		ret.SubtreeRestrictions = append(ret.SubtreeRestrictions, loadRestriction(restrictionDefinition, path, loadError))
+	}
+	if definition.RestrictionTree != nil {
+		expr := loadRestrictionExpr(*definition.RestrictionTree, path, loadError)
+		ret.Restrictions = &expr
+	}
+
+	// subcommands
+	for _, subDefinition := range definition.SubCommands {
+		ret.SubCommands = append(ret.SubCommands, loadCMD(subDefinition, registry, path, loadError))
+	}
+
+	return ret
+}
+
+// loadRestriction builds a restriction from its definition, problems are added to loadError
+func loadRestriction(definition RestrictionDefinition, path []string, loadError *CMDLoadError) Restriction {
+	if _, ok := getRestrictionConcept(definition.Concept); !ok {
+		loadError.addProblem(path, "restriction '%v': unknown concept '%v'", definition.ID, definition.Concept)
+	}
+	// time windows could use the current time
+	if definition.Field == "" && definition.Concept != CMDRestrictionConceptTimeWindow {
+		loadError.addProblem(path, "restriction '%v': missing field", definition.ID)
+	}
+
+	switch definition.Source {
+	case "", CMDRestrictionSourceGeneral, CMDRestrictionSourceInput:
+	default:
+		loadError.addProblem(path, "restriction '%v': unknown source '%v'", definition.ID, definition.Source)
+	}
+
+	return Restriction{
+		ID:      definition.ID,
+		Concept: definition.Concept,
+		Field:   definition.Field,
+		Data:    definition.Data,
+		Source:  definition.Source,
+	}
+}
+
+// loadRestrictionExpr builds a restrictions' expression from its definition, problems are added to loadError
+func loadRestrictionExpr(definition RestrictionDefinition, path []string, loadError *CMDLoadError) RestrictionExpr {
+	children := make([]RestrictionExpr, 0, len(definition.Children))
+	for _, child := range definition.Children {
+		children = append(children, loadRestrictionExpr(child, path, loadError))
+	}
+
+	switch definition.Operator {
+	case "":
+		return RestrictionLeaf(loadRestriction(definition, path, loadError))
+	case RestrictionOperatorAnd:
+		return RestrictionAnd(children...)
+	case RestrictionOperatorOr:
+		return RestrictionOr(children...)
+	case RestrictionOperatorNot:
+		if len(children) != 1 {
+			loadError.addProblem(path, "restriction operator '%v' needs exactly one child", definition.Operator)
+			return RestrictionExpr{Operator: definition.Operator, Children: children}
+		}
+		return RestrictionNot(children[0])
+	}
+
+	loadError.addProblem(path, "unknown restriction operator '%v'", definition.Operator)
+	return RestrictionExpr{Operator: definition.Operator, Children: children}
+}
@@ -1,17 +1,47 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
+	"runtime/debug"
 	"strings"
+	"sync"
 
 	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goagent/message"
+)
+
+const (
+	defaultTimeoutMessage = "Sorry, it is taking too long. The action was cancelled."
+	defaultPanicMessage   = "Sorry, something went wrong."
 )
 
 type CMDManager struct {
-	commands  []CMD
-	errorChan chan error
+	// commands are never modified in place: each change builds a new slice (copy-on-write), so entries being
+	// processed keep working with the slice they got
+	commands       []CMD
+	commandsMutex  sync.RWMutex
+	errorChan      chan error
+	timeoutMessage string
+	panicMessage   string
+	middlewares    []CMDMiddleware
+	// handler for the entries not matching any command
+	unknownCMDHandler CMDHandlerV2
+	// checker for the commands' RequiredRoles
+	roleChecker RoleChecker
+	// handler for the entries exceeding a rate limit
+	throttledHandler CMDHandlerV2
+	// commands' executions (MaxConcurrency / Cooldown)
+	executions executionTracker
+	// requests waiting for the user's confirmation (RequireConfirmation)
+	pendingConfirmations pendingActions
+	// requests waiting for a second user's approval (ApproverRoles)
+	pendingApprovals pendingApprovals
+	// approvals' command pattern (GetApprovalsCMD) and audit
+	approvalsPattern string
+	approvalRecorder ApprovalRecorder
 }
 
 func NewCMDManager(errorChan chan error) *CMDManager {
@@ -24,11 +54,159 @@ func NewCMDManager(errorChan chan error) *CMDManager {
 func (st *CMDManager) initialize(errorChan chan error) {
 	st.commands = make([]CMD, 0)
 	st.errorChan = errorChan
+	st.timeoutMessage = defaultTimeoutMessage
+	st.panicMessage = defaultPanicMessage
+	st.middlewares = make([]CMDMiddleware, 0)
 }
 
+// AddCommand adds a command. Commands are matched by priority (higher first) and then by registration order.
+// Returns an error if a word pattern is already used by a sibling command having the same priority, or if the
+// command's ID is already used.
 func (st *CMDManager) AddCommand(cmd CMD) error {
+	if err := st.prepareCMD(&cmd, nil); err != nil {
+		return err
+	}
+
+	st.commandsMutex.Lock()
+	defer st.commandsMutex.Unlock()
+
+	if cmd.ID != "" && findCMDByID(st.commands, cmd.ID) >= 0 {
+		return NewCMDError(CMDErrorTypeConflict, fmt.Sprintf("command ID '%v' already exists", cmd.ID))
+	}
+
+	if err := checkWordConflicts(st.commands, cmd); err != nil {
+		return err
+	}
+
+	// save the command
+	st.commands = insertCMDByPriority(st.commands, cmd)
+
+	return nil
+}
+
+// RemoveCommand removes the command having the given ID. It is safe to be called while processing entries.
+// The command's pending confirmations / approvals get cancelled.
+func (st *CMDManager) RemoveCommand(id string) error {
+	st.commandsMutex.Lock()
+	pos := findCMDByID(st.commands, id)
+	if pos < 0 {
+		st.commandsMutex.Unlock()
+		return NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no command '%v'", id))
+	}
+
+	st.commands = removeCMD(st.commands, pos)
+	st.commandsMutex.Unlock()
+
+	st.cancelPendingRequests(id)
+
+	return nil
+}
+
+// ReplaceCommand replaces the command having the given ID. The new command keeps the same ID.
+// It is safe to be called while processing entries. The replaced command's pending confirmations / approvals get
+// cancelled.
+func (st *CMDManager) ReplaceCommand(id string, cmd CMD) error {
+	cmd.ID = id
+	if err := st.prepareCMD(&cmd, nil); err != nil {
+		return err
+	}
+
+	st.commandsMutex.Lock()
+	pos := findCMDByID(st.commands, id)
+	if pos < 0 {
+		st.commandsMutex.Unlock()
+		return NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no command '%v'", id))
+	}
+
+	commands := removeCMD(st.commands, pos)
+	if err := checkWordConflicts(commands, cmd); err != nil {
+		st.commandsMutex.Unlock()
+		return err
+	}
+
+	st.commands = insertCMDByPriority(commands, cmd)
+	st.commandsMutex.Unlock()
+
+	st.cancelPendingRequests(id)
+
+	return nil
+}
+
+// cancelPendingRequests cancels the pending confirmations / approvals of the top level command having the given ID
+// (removed or replaced), so they can't run a stale command
+func (st *CMDManager) cancelPendingRequests(rootID string) {
+	for _, action := range st.pendingConfirmations.removeByRootID(rootID) {
+		message.SendMessageToOutput(fmt.Sprintf(confirmationCommandGoneMessage, action.request.Pattern), action.request.Entry.InputMetadata, nil, action.request.Outputs)
+	}
+
+	st.cancelApprovalsByRootID(rootID)
+}
+
+// GetCommand returns the command having the given ID
+func (st *CMDManager) GetCommand(id string) (CMD, bool) {
+	commands := st.getCommands()
+
+	pos := findCMDByID(commands, id)
+	if pos < 0 {
+		return CMD{}, false
+	}
+
+	return commands[pos], true
+}
+
+// getCommands returns the current commands. The returned slice must not be modified.
+func (st *CMDManager) getCommands() []CMD {
+	st.commandsMutex.RLock()
+	defer st.commandsMutex.RUnlock()
+
+	return st.commands
+}
+
+// findCMDByID returns the position of the command having the given ID, -1 if none
+func findCMDByID(cmds []CMD, id string) int {
+	if id == "" {
+		return -1
+	}
+
+	for i := 0; i < len(cmds); i++ {
+		if cmds[i].ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// removeCMD returns a new slice without the command at the given position
+func removeCMD(cmds []CMD, pos int) []CMD {
+	ret := make([]CMD, 0, len(cmds)-1)
+	ret = append(ret, cmds[:pos]...)
+
+	return append(ret, cmds[pos+1:]...)
+}
+
+// SetTimeoutMessage sets the default message to reply when a handler exceeds its CMD's timeout
+func (st *CMDManager) SetTimeoutMessage(message string) {
+	st.timeoutMessage = message
+}
+
+// SetPanicMessage sets the message to reply when a handler panics
+func (st *CMDManager) SetPanicMessage(message string) {
+	st.panicMessage = message
+}
+
+// SetUnknownCMDHandler sets the handler for the entries not matching any command. The closest patterns are passed
+// in the request's metadata (CMDExtraDataSuggestions).
+func (st *CMDManager) SetUnknownCMDHandler(handler CMDHandlerV2) {
+	st.unknownCMDHandler = handler
+}
+
+// prepareCMD compiles the regex patterns, lowercases the word patterns and propagates the inherited values from the
+// parent, for the given command and all its subcommands
+func (st *CMDManager) prepareCMD(cmd *CMD, parent *CMD) error {
 	// compile && save the regex pattern
 	if cmd.PatternType == CMDTypeRegex {
+		cmd.compiledRegex = nil
 		// compile the regex patterns
 		for i := 0; i < len(cmd.Pattern); i++ {
 			compiledPattern, err := regexp.Compile(cmd.Pattern[i])
@@ -48,124 +226,274 @@ func (st *CMDManager) AddCommand(cmd CMD) error {
 		}
 	}
 
-	// save the command
-	st.commands = append(st.commands, cmd)
+	// params must be checkable at match time
+	for i := 0; i < len(cmd.Params); i++ {
+		if err := validateParamDefinition(cmd.Params[i]); err != nil {
+			return fmt.Errorf("command '%v': %v", getFirstPattern(*cmd), err.Error())
+		}
+	}
+
+	// inherited values
+	var inheritedRestrictions []Restriction
+	cmd.path = cmd.ID
+	if cmd.path == "" {
+		cmd.path = getFirstPattern(*cmd)
+	}
+	cmd.rootID = cmd.ID
+	if parent != nil {
+		inheritedRestrictions = parent.subtreeRestrictions
+		cmd.path = parent.path + " " + getFirstPattern(*cmd)
+		cmd.rootID = parent.rootID
+	}
+	cmd.inheritSubtreeRestrictions(inheritedRestrictions)
+	if parent != nil {
+		if cmd.Timeout == 0 {
+			cmd.Timeout = parent.Timeout
+		}
+		if cmd.TimeoutMessage == "" {
+			cmd.TimeoutMessage = parent.TimeoutMessage
+		}
+	}
+
+	// subcommands (copied to not modify the original slice)
+	if len(cmd.SubCommands) > 0 {
+		subCommands := make([]CMD, len(cmd.SubCommands))
+		copy(subCommands, cmd.SubCommands)
+		for i := 0; i < len(subCommands); i++ {
+			if err := st.prepareCMD(&subCommands[i], cmd); err != nil {
+				return err
+			}
+
+			if err := checkWordConflicts(subCommands[:i], subCommands[i]); err != nil {
+				return err
+			}
+		}
+		sortCMDsByPriority(subCommands)
+		cmd.SubCommands = subCommands
+	}
 
 	return nil
 }
 
+// Process processes the entry using the matching command's handler
 func (st *CMDManager) Process(entry botio.InputEntry, outputs []botio.Output) error {
+	return st.ProcessContext(context.Background(), entry, outputs)
+}
+
+// ProcessContext processes the entry using the matching command's handler. The context is passed to the handler.
+// Returns the handler's error.
+func (st *CMDManager) ProcessContext(ctx context.Context, entry botio.InputEntry, outputs []botio.Output) error {
+	// confirmation replies (yes <token>) for the conversation's pending command
+	if isConfirmation, err := st.processConfirmation(ctx, entry, outputs); isConfirmation {
+		return err
+	}
 
-	cmd, pattern, handlerType, cmdContent, extraData, err := st.matchCMDs(st.commands, entry.Query, entry.InputMetadata, entry.GeneralMetadata)
+	match, err := st.matchCMDs(st.getCommands(), entry.Query, entry.InputMetadata, entry.GeneralMetadata)
 	if err != nil {
-		if st.errorChan != nil {
-			st.errorChan <- err
+		errorType := CMDErrorTypeNoCommand
+		if cmdError, ok := err.(*CMDError); ok {
+			errorType = cmdError.GetType()
+		}
+		st.publishError(NewErrorEvent(errorType, err, entry))
+
+		if errorType == CMDErrorTypeNoCommand && st.unknownCMDHandler != nil {
+			return st.runHandler(st.wrapHandler(st.unknownCMDHandler), CMDRequest{
+				Context:     ctx,
+				Content:     strings.TrimSpace(entry.Query),
+				Metadata:    match.ExtraData,
+				HandlerType: CMDHandlerTypeUnknown,
+				Entry:       entry,
+				Outputs:     outputs,
+			})
 		}
-	} else {
-		switch handlerType {
-		case CMDHandlerTypeDefault:
-			if cmd.Handler != nil {
-				cmd.Handler(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
 
-		case CMDHandlerTypeError:
-			if cmd.HandlerError != nil {
-				cmd.HandlerError(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+		return nil
+	}
 
-		case CMDHandlerTypeRestrictions:
-			if cmd.HandlerRestrictions != nil {
-				cmd.HandlerRestrictions(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+	switch match.HandlerType {
+	case CMDHandlerTypeDefault,
+		CMDHandlerTypeError,
+		CMDHandlerTypeRestrictions,
+		CMDHandlerTypeParams,
+		CMDHandlerTypeParamsWrongType,
+		CMDHandlerTypeParamsMissing,
+		CMDHandlerTypeParamsExtra:
+		request := CMDRequest{
+			Context:     ctx,
+			CMD:         match.CMD,
+			Pattern:     match.Pattern,
+			Content:     match.Content,
+			Metadata:    match.ExtraData,
+			HandlerType: match.HandlerType,
+			Entry:       entry,
+			Outputs:     outputs,
+		}
 
-		case CMDHandlerTypeParams:
-			if cmd.HandlerParams != nil {
-				cmd.HandlerParams(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+		// destructive commands run only once the user confirms
+		if isExecution(match.HandlerType) && match.CMD.RequireConfirmation {
+			st.requestConfirmation(request)
+			return nil
+		}
 
-		case CMDHandlerTypeParamsWrongType:
-			if cmd.HandlerParamsWrongType != nil {
-				cmd.HandlerParamsWrongType(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+		// privileged commands run only once another user approves them
+		return st.dispatchOrRequestApproval(request)
 
-		case CMDHandlerTypeParamsMissing:
-			if cmd.HandlerParamsMissing != nil {
-				cmd.HandlerParamsMissing(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+	default:
+		log.Printf("Unknown CMDHandlerType: %v", match.HandlerType)
+	}
 
-		case CMDHandlerTypeParamsExtra:
-			if cmd.HandlerParamsExtra != nil {
-				cmd.HandlerParamsExtra(cmd, pattern, cmdContent, extraData, handlerType, entry.InputMetadata, entry.GeneralMetadata, outputs)
-			}
+	return nil
+}
 
-		default:
-			log.Printf("Unknown CMDHandlerType: %v", handlerType)
+// dispatch runs the request's handler. MaxConcurrency / Cooldown are enforced for the executions.
+// Returns the handler's error.
+func (st *CMDManager) dispatch(request CMDRequest) error {
+	handler := request.CMD.getHandler(request.HandlerType)
+	// MaxConcurrency / Cooldown apply to the executions only, once the middlewares let them through
+	if isExecution(request.HandlerType) && request.CMD.hasExecutionLimits() {
+		handler = st.limitExecutions(handler)
+	}
+	if handler == nil && len(st.middlewares) == 0 {
+		return nil
+	}
+	handler = st.wrapHandler(handler)
+
+	err := st.runHandler(handler, request)
+	if err != nil {
+		event, ok := err.(ErrorEvent)
+		if !ok {
+			errorType := CMDErrorTypeHandler
+			if cmdError, ok := err.(*CMDError); ok {
+				errorType = cmdError.GetType()
+			}
+			event = NewErrorEvent(errorType, err, request.Entry)
 		}
+		event.Pattern = request.Pattern
+		event.HandlerType = request.HandlerType
+		st.publishError(event)
+
+		return event
 	}
 
 	return nil
 }
 
+// isExecution returns true whether the handler type executes the command (not an error / restrictions handler)
+func isExecution(handlerType string) bool {
+	return handlerType == CMDHandlerTypeDefault || handlerType == CMDHandlerTypeParams
+}
+
+// runHandler runs the handler enforcing the CMD's timeout. A timeout message is sent back if the handler exceeds it.
+func (st *CMDManager) runHandler(handler CMDHandlerV2, request CMDRequest) error {
+	if request.CMD.Timeout <= 0 {
+		return st.callHandler(handler, request)
+	}
+
+	parentCtx := request.Context
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parentCtx, request.CMD.Timeout)
+	defer cancel()
+	request.Context = ctx
+
+	done := make(chan error, 1)
+	go func() {
+		done <- st.callHandler(handler, request)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-ctx.Done():
+		// the parent context is done: no timeout
+		if parentCtx.Err() != nil {
+			return parentCtx.Err()
+		}
+	}
+
+	// timeout
+	timeoutMessage := request.CMD.TimeoutMessage
+	if timeoutMessage == "" {
+		timeoutMessage = st.timeoutMessage
+	}
+	message.SendMessageToOutput(timeoutMessage, request.Entry.InputMetadata, nil, request.Outputs)
+
+	return NewCMDError(CMDErrorTypeTimeout, fmt.Sprintf("'%v' exceeded the timeout (%v)", request.Pattern, request.CMD.Timeout))
+}
+
+// callHandler calls the handler recovering from panics. The user gets a message back if the handler panics.
+func (st *CMDManager) callHandler(handler CMDHandlerV2, request CMDRequest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Printf("Handler for '%v' panicked: %v\n%s", request.Pattern, r, stack)
+
+			message.SendMessageToOutput(st.panicMessage, request.Entry.InputMetadata, nil, request.Outputs)
+
+			event := NewErrorEvent(CMDErrorTypePanic, fmt.Errorf("handler for '%v' panicked: %v", request.Pattern, r), request.Entry)
+			event.Stack = stack
+			err = event
+		}
+	}()
+
+	return handler(request)
+}
+
+// publishError sends the error to the error channel (if any) without blocking: the error is discarded if the
+// channel is full
+func (st *CMDManager) publishError(event ErrorEvent) {
+	if st.errorChan == nil {
+		return
+	}
+
+	select {
+	case st.errorChan <- event:
+	default:
+		log.Printf("Error channel is full, discarded error: %v", event.Error())
+	}
+}
+
 // matchCMDs finds for the best CMD (command) match.
-// Returns CMD, bool, err
-// CMD					==> best match command
-// string				==> pattern that matches
-// string				==> CMD handler type true, which handler type should be used
-// string				==> content
-// map[string][string]	==> extra information related to the cmd
-// err		==> error
-func (st *CMDManager) matchCMDs(cmds []CMD, content string, inputMetadata botio.Metadata, generalMetadata botio.Metadata) (CMD, string, string, string, map[string]interface{}, error) {
+// Returns a CMDMatch built for this request (bound params included) and an error if no command matches.
+func (st *CMDManager) matchCMDs(cmds []CMD, content string, inputMetadata botio.Metadata, generalMetadata botio.Metadata) (CMDMatch, error) {
 	content = strings.TrimSpace(content)
 	if content == "" {
-		return CMD{}, "", CMDHandlerTypeError, content, nil, NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no commands for '%v'", content))
+		return CMDMatch{HandlerType: CMDHandlerTypeError, Content: content}, NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no commands for '%v'", content))
 	}
 
-	// split the content into words separated by blank spaces
-	words := strings.Split(content, " ")
-
-	var (
-		cmd2ret      CMD
-		patternMatch string
-	)
+	// split the content into words (quoted values are kept as a single word)
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return CMDMatch{HandlerType: CMDHandlerTypeError, Content: content}, NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no commands for '%v'", content))
+	}
+	words := getTokenValues(tokens)
 
 	for _, cmd := range cmds {
-		match := false
-		extraContent := ""
-
-		switch cmd.PatternType {
-		// regex
-		case CMDTypeRegex:
-			for i := 0; i < len(cmd.compiledRegex); i++ {
-				match = cmd.compiledRegex[i].MatchString(content)
-
-				if match {
-					// saved the pattern that matches
-					patternMatch = cmd.Pattern[i]
-					break
-					// TODO ::: get the extracontent from the regex
-				}
+		if pMatch, match := matchPattern(cmd, content, tokens, words); match {
+			patternMatch := pMatch.pattern
+			extraContent := pMatch.extraContent
+			// regex that matches along with its submatches' positions
+			matchRegex := pMatch.regex
+			matchSubmatches := pMatch.submatches
+
+			// the CMD is a copy, but its Params' slice is shared: the bound params go into a new slice
+			cmd.Params = copyParams(cmd.Params)
+			ret := CMDMatch{
+				CMD:     cmd,
+				Pattern: patternMatch,
+				Content: content,
 			}
 
-		// first word
-		case CMDTypeWord:
-			for i := 0; i < len(cmd.Pattern); i++ {
-				match = strings.ToLower(words[0]) == cmd.Pattern[i]
-				if match {
-					// saved the pattern that matches
-					patternMatch = cmd.Pattern[i]
-					// extra content to keep parsing
-					extraContent = content[len(words[0]):]
-					break
-				}
+			// check cmd restrictions (subtree restrictions could be overridden by the subcommands)
+			if result := cmd.getRestrictionExpr(false).Evaluate(inputMetadata, generalMetadata); !result.OK {
+				return getRestrictionsMatch(ret, result), nil
 			}
-		}
-
-		if match {
-			cmd2ret = cmd
 
-			// check cmd restrictions
-			if canExecute, errMessage := cmd2ret.CanExecute(inputMetadata, generalMetadata); !canExecute {
-				return cmd2ret, patternMatch, CMDHandlerTypeRestrictions, content, map[string]interface{}{"message": errMessage}, nil
+			// check cmd required roles
+			if result := st.checkRequiredRoles(cmd, generalMetadata); !result.OK {
+				return getRestrictionsMatch(ret, result), nil
 			}
 
 			// get the extra content to parse (extra content == content - cmd)
@@ -175,74 +503,79 @@ func (st *CMDManager) matchCMDs(cmds []CMD, content string, inputMetadata botio.
 
 			// check for subCommands
 			if len(cmd.SubCommands) > 0 {
-				// do not check for params
-				checkForParams = false
-				extraCmd2ret, patternMatch2, useHandler, content2, extraData, err2 := st.matchCMDs(cmd.SubCommands, extraContent, inputMetadata, generalMetadata)
-				if err2 == nil {
-					return extraCmd2ret, patternMatch2, useHandler, content2, extraData, nil
+				// do not check for params (regex commands' capture groups are checked if there is no unmatched content)
+				checkForParams = matchRegex != nil && extraContent == ""
+				if subMatch, err2 := st.matchCMDs(cmd.SubCommands, extraContent, inputMetadata, generalMetadata); err2 == nil {
+					// the subcommand gets the regex command's capture groups as params
+					if matchRegex != nil {
+						subMatch.CMD.Params = inheritParams(subMatch.CMD.Params, getRegexCaptureParams(cmd.Params, matchRegex, content, matchSubmatches))
+					}
+					return subMatch, nil
 				}
 			}
 
+			// the command handles the request: check its subtree restrictions (inherited ones included)
+			if result := cmd.CheckRestrictions(inputMetadata, generalMetadata); !result.OK {
+				return getRestrictionsMatch(ret, result), nil
+			}
+
 			// check for params
 			if checkForParams && len(cmd.Params) > 0 {
-				totalParameters := 0
-
-				for i := 0; i < len(cmd.Params); i++ {
-					if i < len(words)-1 {
-						totalParameters++
-
-						cmd.Params[i].Value = words[i+1]
-						// parameter type checking
-						if !cmd.Params[i].isExpectedType() {
-							// pass back the wrong param data
-							return cmd2ret, patternMatch, CMDHandlerTypeParamsWrongType, content, map[string]interface{}{
-								CMDExtraDataParametersIncorrectType: []CMDParam{cmd.Params[i]},
-							}, nil
-						}
-					} else {
-						// parameter required checking
-						if cmd.Params[i].Required {
-							// return insufficient parameters handler
-							// pass back the missing param data
-							return cmd2ret, patternMatch, CMDHandlerTypeParamsMissing, content, map[string]interface{}{
-								CMDExtraDataParametersMissing: []CMDParam{cmd.Params[i]},
-							}, nil
-						}
-					}
-				}
-
-				// more parameters than expected
-				if len(words)-1 > len(cmd.Params) {
-					// pass back the extra params
-					extraParams := make([]CMDParam, 0)
-					for c := len(cmd.Params) + 1; c < len(words); c++ {
-						extraParams = append(extraParams, CMDParam{
-							Value: words[c],
-						})
-					}
-
-					// return extra parameters handler
-					return cmd2ret, patternMatch, CMDHandlerTypeParamsExtra, content, map[string]interface{}{
-						CMDExtraDataParametersExtra: extraParams,
-					}, nil
+				var (
+					handlerType string
+					extraData   map[string]interface{}
+				)
+				if matchRegex != nil {
+					// regex capture groups
+					handlerType, extraData = bindRegexParams(cmd.Params, matchRegex, content, matchSubmatches)
+				} else {
+					handlerType, extraData = bindParams(cmd.Params, words[1:])
 				}
 
-				// return CMDHandlerTypeParams ONLY if there are at least one parameter
-				if totalParameters > 0 {
-					return cmd2ret, patternMatch, CMDHandlerTypeParams, content, nil, nil
+				if handlerType != "" {
+					ret.HandlerType = handlerType
+					ret.ExtraData = extraData
+					return ret, nil
 				}
 			}
 
 			// return CMD if there is no more content to parse
 			if extraContent == "" {
-				return cmd2ret, patternMatch, CMDHandlerTypeDefault, content, nil, nil
+				ret.HandlerType = CMDHandlerTypeDefault
+				return ret, nil
 			}
 
 			// use errorHandler instead of handler
-			return cmd2ret, patternMatch, CMDHandlerTypeError, extraContent, nil, nil
+			ret.HandlerType = CMDHandlerTypeError
+			ret.Content = extraContent
+			if len(cmd.SubCommands) > 0 {
+				// closest subcommands to the unknown one
+				ret.ExtraData = map[string]interface{}{
+					CMDExtraDataSuggestions: getSuggestions(cmd.SubCommands, getTokenValues(tokenize(extraContent))[0]),
+				}
+			}
+			return ret, nil
 		}
 
 	}
 
-	return CMD{}, "", CMDHandlerTypeError, content, nil, NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no commands for '%v'", content))
+	return CMDMatch{
+		HandlerType: CMDHandlerTypeError,
+		Content:     content,
+		ExtraData: map[string]interface{}{
+			CMDExtraDataSuggestions: getSuggestions(cmds, words[0]),
+		},
+	}, NewCMDError(CMDErrorTypeNoCommand, fmt.Sprintf("no commands for '%v'", content))
+}
+
+// getRestrictionsMatch returns the match for the restrictions handler
+func getRestrictionsMatch(match CMDMatch, result RestrictionResult) CMDMatch {
+	match.HandlerType = CMDHandlerTypeRestrictions
+	match.ExtraData = map[string]interface{}{
+		"message":                           result.Message,
+		CMDExtraDataRestrictionFailedBranch: result.FailedBranch,
+		CMDExtraDataRestrictionResult:       result,
+	}
+
+	return match
 }
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+// TestProcessContextConcurrentParams processes entries with distinct params from many workers at once (run it with
+// -race). Each handler must get its own params and the CMD's Params must not be modified.
+func TestProcessContextConcurrentParams(t *testing.T) {
+	const (
+		workers  = 20
+		requests = 50
+	)
+
+	var (
+		handled    int64
+		mismatches int64
+	)
+
+	manager := NewCMDManager(make(chan error, workers*requests))
+	err := manager.AddCommand(CMD{
+		ID:          "echo",
+		PatternType: CMDTypeWord,
+		Pattern:     []string{"echo"},
+		Params: []CMDParam{
+			{ID: "name", Type: CMDParamTypeString, Required: true},
+			{ID: "number", Type: CMDParamTypeInt, Required: true},
+		},
+		HandlerParamsV2: func(request CMDRequest) error {
+			atomic.AddInt64(&handled, 1)
+
+			name, err := request.GetParam("name")
+			if err != nil {
+				return err
+			}
+			number, err := request.GetParam("number")
+			if err != nil {
+				return err
+			}
+
+			// each entry sends "echo worker<n> <n>"
+			if name.Value != "worker"+number.Value {
+				atomic.AddInt64(&mismatches, 1)
+			}
+
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddCommand: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := 0; i < requests; i++ {
+				number := worker*requests + i
+				entry := botio.InputEntry{
+					Query:           fmt.Sprintf("echo worker%v %v", number, number),
+					GeneralMetadata: map[string]interface{}{},
+				}
+				if err := manager.ProcessContext(context.Background(), entry, nil); err != nil {
+					t.Errorf("ProcessContext: %v", err)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if handled != workers*requests {
+		t.Errorf("expected %v handled entries, got %v", workers*requests, handled)
+	}
+	if mismatches > 0 {
+		t.Errorf("%v handlers got params from another entry", mismatches)
+	}
+
+	cmd, ok := manager.GetCommand("echo")
+	if !ok {
+		t.Fatal("command 'echo' not found")
+	}
+	for _, param := range cmd.Params {
+		if param.Value != "" {
+			t.Errorf("CMD.Params modified: param '%v' has value '%v'", param.ID, param.Value)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CMDMatch is the result of matching an entry against the commands. It is built for each request: CMD.Params hold
+// the values bound for this request only, so it can be handed to a handler without sharing state with other workers.
+type CMDMatch struct {
+	// CMD that matched (copy holding the bound params)
+	CMD CMD
+	// exact CMD pattern that matched
+	Pattern string
+	// handler type to be used
+	HandlerType string
+	// content to parse
+	Content string
+	// extra information related to the CMD (missing params, restrictions, suggestions, ...)
+	ExtraData map[string]interface{}
+}
+
+// cmdPatternMatch is the result of matching a CMD's patterns (the CMD itself, not its subcommands)
+type cmdPatternMatch struct {
+	// exact CMD pattern that matched
+	pattern string
+	// content to keep parsing (subcommands / params)
+	extraContent string
+	// regex that matched along with its submatches' positions (regex CMDs only)
+	regex      *regexp.Regexp
+	submatches []int
+}
+
+// matchPattern returns true whether any of the CMD's patterns matches the content
+func matchPattern(cmd CMD, content string, tokens []token, words []string) (cmdPatternMatch, bool) {
+	ret := cmdPatternMatch{}
+
+	switch cmd.PatternType {
+	// regex
+	case CMDTypeRegex:
+		for i := 0; i < len(cmd.compiledRegex); i++ {
+			submatches := cmd.compiledRegex[i].FindStringSubmatchIndex(content)
+			if submatches != nil {
+				// saved the pattern that matches
+				ret.pattern = cmd.Pattern[i]
+				ret.regex = cmd.compiledRegex[i]
+				ret.submatches = submatches
+				// unmatched content to keep parsing (only for subcommands)
+				if len(cmd.SubCommands) > 0 {
+					ret.extraContent = content[submatches[1]:]
+				}
+				return ret, true
+			}
+		}
+
+	// first word
+	case CMDTypeWord:
+		for i := 0; i < len(cmd.Pattern); i++ {
+			if strings.ToLower(words[0]) == cmd.Pattern[i] {
+				// saved the pattern that matches
+				ret.pattern = cmd.Pattern[i]
+				// extra content to keep parsing
+				ret.extraContent = content[tokens[0].end:]
+				return ret, true
+			}
+		}
+	}
+
+	return ret, false
+}
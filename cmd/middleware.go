@@ -0,0 +1,29 @@
+package cmd
+
+// CMDMiddleware wraps the handler dispatch (for all handler types). A middleware could:
+//   - inspect or modify the request (including its InputEntry) before calling next
+//   - short-circuit the dispatch by not calling next (i.e. sending its own reply to request.Outputs)
+//   - observe the outcome (error returned by next)
+type CMDMiddleware func(next CMDHandlerV2) CMDHandlerV2
+
+// Use adds middlewares to wrap the handlers' dispatch. The first added middleware is the outermost one.
+// Middlewares should be added before processing entries.
+func (st *CMDManager) Use(middlewares ...CMDMiddleware) {
+	st.middlewares = append(st.middlewares, middlewares...)
+}
+
+// wrapHandler wraps the handler with all middlewares. A nil handler is replaced by a no-op handler, so middlewares
+// run no matter whether the CMD has a handler for the given type.
+func (st *CMDManager) wrapHandler(handler CMDHandlerV2) CMDHandlerV2 {
+	if handler == nil {
+		handler = func(request CMDRequest) error {
+			return nil
+		}
+	}
+
+	for i := len(st.middlewares) - 1; i >= 0; i-- {
+		handler = st.middlewares[i](handler)
+	}
+
+	return handler
+}
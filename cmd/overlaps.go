@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// two sibling commands share a word pattern
+	CMDOverlapTypeWord = "word"
+	// a sibling's regex pattern matches the other's pattern (or both have the same regex)
+	CMDOverlapTypeRegex = "regex"
+)
+
+// CMDOverlap describes two sibling commands that could match the same content
+type CMDOverlap struct {
+	Type string
+	// path (first pattern of each ancestor) to the commands, empty for top level commands
+	Path []string
+	// overlapping pattern
+	Pattern string
+	// first pattern of each overlapping command. The first one wins (priority, then registration order).
+	Commands [2]string
+}
+
+func (st CMDOverlap) String() string {
+	return fmt.Sprintf("%v %v: '%v' shadows '%v' (pattern: '%v')", st.Path, st.Type, st.Commands[0], st.Commands[1], st.Pattern)
+}
+
+// GetOverlaps returns all overlapping sibling commands across the commands' tree
+func (st *CMDManager) GetOverlaps() []CMDOverlap {
+	return findOverlaps(st.getCommands(), []string{})
+}
+
+// findOverlaps returns the overlapping commands for the given siblings and their subcommands
+func findOverlaps(cmds []CMD, path []string) []CMDOverlap {
+	ret := make([]CMDOverlap, 0)
+
+	for i := 0; i < len(cmds); i++ {
+		for j := i + 1; j < len(cmds); j++ {
+			ret = append(ret, findCMDsOverlaps(cmds[i], cmds[j], path)...)
+		}
+	}
+
+	for i := 0; i < len(cmds); i++ {
+		if len(cmds[i].SubCommands) > 0 {
+			subPath := make([]string, len(path), len(path)+1)
+			copy(subPath, path)
+			ret = append(ret, findOverlaps(cmds[i].SubCommands, append(subPath, getFirstPattern(cmds[i])))...)
+		}
+	}
+
+	return ret
+}
+
+// findCMDsOverlaps returns the overlaps between two siblings (first has precedence over second)
+func findCMDsOverlaps(first CMD, second CMD, path []string) []CMDOverlap {
+	ret := make([]CMDOverlap, 0)
+	commands := [2]string{getFirstPattern(first), getFirstPattern(second)}
+
+	for i := 0; i < len(first.Pattern); i++ {
+		for j := 0; j < len(second.Pattern); j++ {
+			overlapType := ""
+			pattern := first.Pattern[i]
+
+			switch {
+			case first.PatternType == CMDTypeWord && second.PatternType == CMDTypeWord:
+				if first.Pattern[i] == second.Pattern[j] {
+					overlapType = CMDOverlapTypeWord
+				}
+
+			case first.PatternType == CMDTypeRegex && second.PatternType == CMDTypeRegex:
+				if first.Pattern[i] == second.Pattern[j] {
+					overlapType = CMDOverlapTypeRegex
+				}
+
+			case first.PatternType == CMDTypeRegex && i < len(first.compiledRegex):
+				if first.compiledRegex[i].MatchString(second.Pattern[j]) {
+					overlapType = CMDOverlapTypeRegex
+					pattern = second.Pattern[j]
+				}
+
+			case second.PatternType == CMDTypeRegex && j < len(second.compiledRegex):
+				if second.compiledRegex[j].MatchString(first.Pattern[i]) {
+					overlapType = CMDOverlapTypeRegex
+				}
+			}
+
+			if overlapType != "" {
+				ret = append(ret, CMDOverlap{
+					Type:     overlapType,
+					Path:     path,
+					Pattern:  pattern,
+					Commands: commands,
+				})
+			}
+		}
+	}
+
+	return ret
+}
+
+// checkWordConflicts returns an error if the command shares a word pattern with a sibling having the same priority
+func checkWordConflicts(siblings []CMD, cmd CMD) error {
+	if cmd.PatternType != CMDTypeWord {
+		return nil
+	}
+
+	for i := 0; i < len(siblings); i++ {
+		if siblings[i].PatternType != CMDTypeWord || siblings[i].Priority != cmd.Priority {
+			continue
+		}
+
+		for _, pattern := range cmd.Pattern {
+			for _, siblingPattern := range siblings[i].Pattern {
+				if pattern == siblingPattern {
+					return NewCMDError(CMDErrorTypeConflict, fmt.Sprintf("pattern '%v' is already used by '%v' (same priority: %v)", pattern, getFirstPattern(siblings[i]), cmd.Priority))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertCMDByPriority inserts the command after all commands having the same or higher priority
+func insertCMDByPriority(cmds []CMD, cmd CMD) []CMD {
+	pos := len(cmds)
+	for i := 0; i < len(cmds); i++ {
+		if cmds[i].Priority < cmd.Priority {
+			pos = i
+			break
+		}
+	}
+
+	ret := make([]CMD, 0, len(cmds)+1)
+	ret = append(ret, cmds[:pos]...)
+	ret = append(ret, cmd)
+	return append(ret, cmds[pos:]...)
+}
+
+// sortCMDsByPriority sorts the commands by priority (higher first), keeping the registration order for the same
+// priority
+func sortCMDsByPriority(cmds []CMD) {
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return cmds[i].Priority > cmds[j].Priority
+	})
+}
+
+// getFirstPattern returns the command's first pattern
+func getFirstPattern(cmd CMD) string {
+	if len(cmd.Pattern) == 0 {
+		return ""
+	}
+
+	return cmd.Pattern[0]
+}
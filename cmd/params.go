@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// value for a switch param present in the content
+	paramSwitchOnValue = "true"
+	// value for a switch param not present in the content
+	paramSwitchOffValue = "false"
+)
+
+// paramWord is a word to be bound to a param
+type paramWord struct {
+	// param name (empty for positional words)
+	name  string
+	value string
+	// whether the word has the name=value form
+	hasValue bool
+	// original word
+	word string
+}
+
+// bindParams binds the words (content after the command) to the given params, by name or by position.
+// Accepted forms:
+//   - positional:	value
+//   - named:		--name=value / -name=value / name=value
+//   - switches:	--name / -name (--name=false to turn it off)
+//
+// Positional words are bound, in order, to the non switch params not bound by name. Params not provided get their
+// Default value.
+//
+// The given params are modified: they must be a per-request copy (see copyParams).
+//
+// Returns the handler type (empty if there were no params in the content) and the extra data for the handler.
+func bindParams(params []CMDParam, words []string) (string, map[string]interface{}) {
+	bound := make([]bool, len(params))
+	extraParams := make([]CMDParam, 0)
+	positional := make([]string, 0)
+	totalParameters := 0
+
+	// reset the values
+	for i := 0; i < len(params); i++ {
+		params[i].Value = ""
+	}
+
+	// named params
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+
+		pw := parseParamWord(word)
+		index := -1
+		if pw.name != "" {
+			index = findParamByName(params, pw.name)
+		}
+
+		if index < 0 {
+			// unknown flags (--x, -x) are extra params, unknown name=value words are positional values
+			if strings.HasPrefix(word, "-") && pw.name != "" {
+				extraParams = append(extraParams, CMDParam{ID: pw.name, Value: word})
+				continue
+			}
+
+			positional = append(positional, word)
+			continue
+		}
+
+		if params[index].IsSwitch {
+			params[index].Value = paramSwitchOnValue
+			if pw.hasValue {
+				params[index].Value = pw.value
+			}
+		} else {
+			if !pw.hasValue {
+				// a non switch param needs a value
+				extraParams = append(extraParams, CMDParam{ID: pw.name, Value: word})
+				continue
+			}
+			params[index].Value = pw.value
+		}
+
+		bound[index] = true
+		totalParameters++
+	}
+
+	// positional params
+	for i := 0; i < len(params) && len(positional) > 0; i++ {
+		if bound[i] || params[i].IsSwitch {
+			continue
+		}
+
+		params[i].Value = positional[0]
+		positional = positional[1:]
+		bound[i] = true
+		totalParameters++
+	}
+
+	if handlerType, extraData := checkBoundParams(params, bound); handlerType != "" {
+		return handlerType, extraData
+	}
+
+	// more parameters than expected
+	for _, word := range positional {
+		extraParams = append(extraParams, CMDParam{
+			Value: word,
+		})
+	}
+	if len(extraParams) > 0 {
+		// pass back the extra params
+		return CMDHandlerTypeParamsExtra, map[string]interface{}{
+			CMDExtraDataParametersExtra: extraParams,
+		}
+	}
+
+	// CMDHandlerTypeParams ONLY if there are at least one parameter
+	if totalParameters > 0 {
+		return CMDHandlerTypeParams, nil
+	}
+
+	return "", nil
+}
+
+// bindRegexParams binds the regex's capture groups to the given params. Named groups are bound to the params having
+// the same name (ID or alias), unnamed groups are bound, in order, to the params not targeted by any named group.
+// Groups that don't participate in the match are considered not provided.
+//
+// The given params are modified: they must be a per-request copy (see copyParams).
+//
+// Returns the handler type (empty if no group was bound) and the extra data for the handler.
+func bindRegexParams(params []CMDParam, regx *regexp.Regexp, content string, submatches []int) (string, map[string]interface{}) {
+	bound := make([]bool, len(params))
+	totalParameters := 0
+
+	// reset the values
+	for i := 0; i < len(params); i++ {
+		params[i].Value = ""
+	}
+
+	// params targeted by named groups
+	named := make([]bool, len(params))
+	for _, name := range regx.SubexpNames() {
+		if name != "" {
+			if index := findParamByName(params, strings.ToLower(name)); index >= 0 {
+				named[index] = true
+			}
+		}
+	}
+
+	nextPositional := 0
+	for group, name := range regx.SubexpNames() {
+		// group 0 is the whole match
+		if group == 0 {
+			continue
+		}
+
+		index := -1
+		if name != "" {
+			index = findParamByName(params, strings.ToLower(name))
+		} else {
+			// next param not targeted by a named group
+			for nextPositional < len(params) && named[nextPositional] {
+				nextPositional++
+			}
+			if nextPositional < len(params) {
+				index = nextPositional
+				nextPositional++
+			}
+		}
+
+		// no param for the group or the group didn't participate in the match
+		if index < 0 || 2*group+1 >= len(submatches) || submatches[2*group] < 0 {
+			continue
+		}
+
+		params[index].Value = content[submatches[2*group]:submatches[2*group+1]]
+		bound[index] = true
+		totalParameters++
+	}
+
+	if handlerType, extraData := checkBoundParams(params, bound); handlerType != "" {
+		return handlerType, extraData
+	}
+
+	// CMDHandlerTypeParams ONLY if there are at least one parameter
+	if totalParameters > 0 {
+		return CMDHandlerTypeParams, nil
+	}
+
+	return "", nil
+}
+
+// getRegexCaptureParams returns the regex command's capture groups as params (for its matching subcommand): the
+// command's params bound to the groups along with the named groups not targeting any param
+func getRegexCaptureParams(params []CMDParam, regx *regexp.Regexp, content string, submatches []int) []CMDParam {
+	params = copyParams(params)
+	bindRegexParams(params, regx, content, submatches)
+
+	ret := make([]CMDParam, 0, len(params))
+	for i := 0; i < len(params); i++ {
+		if params[i].Value != "" {
+			ret = append(ret, params[i])
+		}
+	}
+
+	for group, name := range regx.SubexpNames() {
+		// the group didn't participate in the match or it is already bound to a param
+		if name == "" || 2*group+1 >= len(submatches) || submatches[2*group] < 0 || findParamByName(params, strings.ToLower(name)) >= 0 {
+			continue
+		}
+
+		ret = append(ret, CMDParam{
+			ID:    strings.ToLower(name),
+			Type:  CMDParamTypeString,
+			Value: content[submatches[2*group]:submatches[2*group+1]],
+		})
+	}
+
+	return ret
+}
+
+// inheritParams appends the inherited params not declared by the command (same ID)
+func inheritParams(params []CMDParam, inherited []CMDParam) []CMDParam {
+	for i := 0; i < len(inherited); i++ {
+		if findParamByName(params, strings.ToLower(inherited[i].ID)) < 0 {
+			params = append(params, inherited[i])
+		}
+	}
+
+	return params
+}
+
+// checkBoundParams sets the default values for the params not bound and checks the required params and types.
+// Returns the handler type (empty if everything is ok) and the extra data for the handler.
+func checkBoundParams(params []CMDParam, bound []bool) (string, map[string]interface{}) {
+	for i := 0; i < len(params); i++ {
+		if !bound[i] {
+			switch {
+			case params[i].Default != "":
+				params[i].Value = params[i].Default
+
+			case params[i].IsSwitch:
+				params[i].Value = paramSwitchOffValue
+
+			case params[i].Required:
+				// parameter required checking
+				// pass back the missing param data
+				return CMDHandlerTypeParamsMissing, map[string]interface{}{
+					CMDExtraDataParametersMissing: []CMDParam{params[i]},
+				}
+
+			default:
+				continue
+			}
+		}
+
+		// parameter type checking
+		if !params[i].isExpectedType() {
+			// pass back the wrong param data
+			return CMDHandlerTypeParamsWrongType, map[string]interface{}{
+				CMDExtraDataParametersIncorrectType: []CMDParam{params[i]},
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// copyParams returns a copy of the params. Matching binds the values into a copy, so the CMD's Params (shared by
+// all copies of the CMD) are never modified.
+func copyParams(params []CMDParam) []CMDParam {
+	if params == nil {
+		return nil
+	}
+
+	ret := make([]CMDParam, len(params))
+	copy(ret, params)
+
+	return ret
+}
+
+// parseParamWord splits a word into name / value
+func parseParamWord(word string) paramWord {
+	ret := paramWord{
+		word:  word,
+		value: word,
+	}
+
+	trimmed := strings.TrimLeft(word, "-")
+	isFlag := trimmed != word
+	// negative numbers are values
+	if _, err := strconv.ParseFloat(word, 64); err == nil {
+		return ret
+	}
+
+	if pos := strings.Index(trimmed, "="); pos > 0 {
+		ret.name = strings.ToLower(trimmed[:pos])
+		ret.value = trimmed[pos+1:]
+		ret.hasValue = true
+	} else if isFlag && trimmed != "" {
+		ret.name = strings.ToLower(trimmed)
+	}
+
+	return ret
+}
+
+// findParamByName returns the index of the param having the given name (ID or alias), -1 if none
+func findParamByName(params []CMDParam, name string) int {
+	for i := 0; i < len(params); i++ {
+		if strings.ToLower(params[i].ID) == name {
+			return i
+		}
+
+		for _, alias := range params[i].Aliases {
+			if strings.ToLower(alias) == name {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sync"
+)
+
+// CMDParamValidator returns an error if the param's value doesn't match the param's type
+type CMDParamValidator func(param CMDParam) error
+
+var (
+	paramTypes      = make(map[string]CMDParamValidator)
+	paramTypesMutex sync.RWMutex
+
+	// compiled CMDParam.Pattern(s)
+	paramPatterns      = make(map[string]*regexp.Regexp)
+	paramPatternsMutex sync.Mutex
+)
+
+func init() {
+	paramTypes[CMDParamTypeString] = func(param CMDParam) error {
+		// any type could be converted to string
+		return nil
+	}
+	paramTypes[CMDParamTypeInt] = func(param CMDParam) error {
+		_, err := param.GetIntValue()
+		return err
+	}
+	paramTypes[CMDParamTypeFloat] = func(param CMDParam) error {
+		_, err := param.GetFloatValue()
+		return err
+	}
+	paramTypes[CMDParamTypeBool] = func(param CMDParam) error {
+		_, err := param.GetBoolValue()
+		return err
+	}
+	paramTypes[CMDParamTypeDuration] = func(param CMDParam) error {
+		_, err := param.GetDurationValue()
+		return err
+	}
+	paramTypes[CMDParamTypeDate] = validateTimeParam
+	paramTypes[CMDParamTypeTime] = validateTimeParam
+	paramTypes[CMDParamTypeDateTime] = validateTimeParam
+	paramTypes[CMDParamTypeEnum] = validateEnumParam
+	paramTypes[CMDParamTypeURL] = func(param CMDParam) error {
+		parsedURL, err := param.GetURLValue()
+		if err != nil {
+			return err
+		}
+		if parsedURL.Scheme == "" || parsedURL.Host == "" {
+			return fmt.Errorf("'%v' is not an absolute URL", param.Value)
+		}
+		return nil
+	}
+	paramTypes[CMDParamTypeEmail] = func(param CMDParam) error {
+		address, err := mail.ParseAddress(param.Value)
+		if err != nil {
+			return err
+		}
+		// only plain addresses, no "Name <address>"
+		if address.Address != param.Value {
+			return fmt.Errorf("'%v' is not a plain email address", param.Value)
+		}
+		return nil
+	}
+	paramTypes[CMDParamTypeRegex] = validateRegexParam
+}
+
+// RegisterParamType registers a custom param type (i.e. jira key, semver). Built-in types can't be replaced.
+func RegisterParamType(name string, validator CMDParamValidator) error {
+	if name == "" || validator == nil {
+		return fmt.Errorf("param type needs a name and a validator")
+	}
+
+	paramTypesMutex.Lock()
+	defer paramTypesMutex.Unlock()
+
+	if _, ok := paramTypes[name]; ok {
+		return fmt.Errorf("param type '%v' already exists", name)
+	}
+	paramTypes[name] = validator
+
+	return nil
+}
+
+// getParamType returns the validator for the given param type
+func getParamType(name string) (CMDParamValidator, bool) {
+	paramTypesMutex.RLock()
+	defer paramTypesMutex.RUnlock()
+
+	validator, ok := paramTypes[name]
+	return validator, ok
+}
+
+// validateParamDefinition returns an error if the param can't be validated at match time: unknown type, enum without
+// allowed values or regex without a valid pattern
+func validateParamDefinition(param CMDParam) error {
+	if _, ok := getParamType(param.Type); param.Type != "" && !ok {
+		return fmt.Errorf("param '%v': unknown type '%v'", param.ID, param.Type)
+	}
+	if param.Type == CMDParamTypeEnum && len(param.AllowedValues) == 0 {
+		return fmt.Errorf("param '%v': enum without allowed values", param.ID)
+	}
+	if param.Type == CMDParamTypeRegex {
+		if _, err := regexp.Compile(param.Pattern); err != nil || param.Pattern == "" {
+			return fmt.Errorf("param '%v': invalid pattern '%v'", param.ID, param.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// validateTimeParam validates date, time and datetime params
+func validateTimeParam(param CMDParam) error {
+	_, err := param.GetTimeValue()
+	return err
+}
+
+// validateEnumParam validates that the value is one of the allowed values
+func validateEnumParam(param CMDParam) error {
+	for i := 0; i < len(param.AllowedValues); i++ {
+		if param.AllowedValues[i] == param.Value {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("'%v' is not an allowed value", param.Value)
+}
+
+// validateRegexParam validates that the value matches the param's pattern
+func validateRegexParam(param CMDParam) error {
+	paramPatternsMutex.Lock()
+	compiledPattern, ok := paramPatterns[param.Pattern]
+	if !ok {
+		var err error
+		// the whole value must match the pattern
+		compiledPattern, err = regexp.Compile(fmt.Sprintf("^(?:%v)$", param.Pattern))
+		if err != nil {
+			paramPatternsMutex.Unlock()
+			return err
+		}
+		paramPatterns[param.Pattern] = compiledPattern
+	}
+	paramPatternsMutex.Unlock()
+
+	if !compiledPattern.MatchString(param.Value) {
+		return fmt.Errorf("'%v' doesn't match '%v'", param.Value, param.Pattern)
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RestrictionConcept returns true whether the metadata value (nil for restrictions without Field) meets the
+// restriction. An error is returned if the restriction is misconfigured (i.e. wrong Data).
+type RestrictionConcept func(restriction Restriction, value interface{}) (bool, error)
+
+var (
+	restrictionConcepts      = make(map[string]RestrictionConcept)
+	restrictionConceptsMutex sync.RWMutex
+
+	// compiled restriction.regex patterns
+	restrictionPatterns      = make(map[string]*regexp.Regexp)
+	restrictionPatternsMutex sync.Mutex
+
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+)
+
+func init() {
+	restrictionConcepts[CMDRestrictionConceptInclude] = func(restriction Restriction, value interface{}) (bool, error) {
+		return containsRestrictionValue(restriction.Data, value), nil
+	}
+	restrictionConcepts[CMDRestrictionConceptExclude] = func(restriction Restriction, value interface{}) (bool, error) {
+		return !containsRestrictionValue(restriction.Data, value), nil
+	}
+	restrictionConcepts[CMDRestrictionConceptRegex] = evaluateRegexRestriction
+	restrictionConcepts[CMDRestrictionConceptPrefix] = func(restriction Restriction, value interface{}) (bool, error) {
+		return evaluateStringRestriction(restriction, value, strings.HasPrefix), nil
+	}
+	restrictionConcepts[CMDRestrictionConceptSuffix] = func(restriction Restriction, value interface{}) (bool, error) {
+		return evaluateStringRestriction(restriction, value, strings.HasSuffix), nil
+	}
+	restrictionConcepts[CMDRestrictionConceptRange] = evaluateRangeRestriction
+	restrictionConcepts[CMDRestrictionConceptTimeWindow] = evaluateTimeWindowRestriction
+}
+
+// RegisterRestrictionConcept registers a custom restriction concept. Built-in concepts can't be replaced.
+func RegisterRestrictionConcept(name string, concept RestrictionConcept) error {
+	if name == "" || concept == nil {
+		return fmt.Errorf("restriction concept needs a name and a function")
+	}
+
+	restrictionConceptsMutex.Lock()
+	defer restrictionConceptsMutex.Unlock()
+
+	if _, ok := restrictionConcepts[name]; ok {
+		return fmt.Errorf("restriction concept '%v' already exists", name)
+	}
+	restrictionConcepts[name] = concept
+
+	return nil
+}
+
+// getRestrictionConcept returns the function for the given restriction concept
+func getRestrictionConcept(name string) (RestrictionConcept, bool) {
+	restrictionConceptsMutex.RLock()
+	defer restrictionConceptsMutex.RUnlock()
+
+	concept, ok := restrictionConcepts[name]
+	return concept, ok
+}
+
+// containsRestrictionValue returns true whether value is one of the given values. reflect.DeepEqual avoids the
+// panic == would raise for non comparable values (i.e. slices).
+func containsRestrictionValue(data []interface{}, value interface{}) bool {
+	for i := 0; i < len(data); i++ {
+		if reflect.DeepEqual(data[i], value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluateStringRestriction returns true whether the value matches (match func) any of the provided strings
+func evaluateStringRestriction(restriction Restriction, value interface{}, match func(s, affix string) bool) bool {
+	strValue := fmt.Sprint(value)
+	for i := 0; i < len(restriction.Data); i++ {
+		if match(strValue, fmt.Sprint(restriction.Data[i])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluateRegexRestriction returns true whether the value matches any of the provided patterns
+func evaluateRegexRestriction(restriction Restriction, value interface{}) (bool, error) {
+	strValue := fmt.Sprint(value)
+	for i := 0; i < len(restriction.Data); i++ {
+		compiledPattern, err := getRestrictionPattern(fmt.Sprint(restriction.Data[i]))
+		if err != nil {
+			return false, err
+		}
+
+		if compiledPattern.MatchString(strValue) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getRestrictionPattern returns the compiled pattern
+func getRestrictionPattern(pattern string) (*regexp.Regexp, error) {
+	restrictionPatternsMutex.Lock()
+	defer restrictionPatternsMutex.Unlock()
+
+	if compiledPattern, ok := restrictionPatterns[pattern]; ok {
+		return compiledPattern, nil
+	}
+
+	compiledPattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	restrictionPatterns[pattern] = compiledPattern
+
+	return compiledPattern, nil
+}
+
+// evaluateRangeRestriction returns true whether the numeric value is in [Data[0], Data[1]]. A nil bound means no
+// limit.
+func evaluateRangeRestriction(restriction Restriction, value interface{}) (bool, error) {
+	if len(restriction.Data) != 2 {
+		return false, fmt.Errorf("range restriction needs 2 values: [min, max]")
+	}
+
+	numValue, err := getFloatValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	if restriction.Data[0] != nil {
+		minValue, err := getFloatValue(restriction.Data[0])
+		if err != nil {
+			return false, err
+		}
+		if numValue < minValue {
+			return false, nil
+		}
+	}
+
+	if restriction.Data[1] != nil {
+		maxValue, err := getFloatValue(restriction.Data[1])
+		if err != nil {
+			return false, err
+		}
+		if numValue > maxValue {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// getFloatValue converts numbers and numeric strings to float64
+func getFloatValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+
+	return 0, fmt.Errorf("'%v' is not a number", value)
+}
+
+// evaluateTimeWindowRestriction returns true whether the time is within the window:
+//
+//	Data[0]: days, i.e. "mon-fri", "sat,sun". Empty or "*" means any day
+//	Data[1]: time of day (end excluded), i.e. "09:00-17:00", "22:00-06:00". Empty or "*" means all day
+//	Data[2]: location, i.e. "UTC", "Europe/Madrid". UTC by default
+//
+// The time is the metadata value (time.Time or RFC3339) or the current time for restrictions without Field.
+func evaluateTimeWindowRestriction(restriction Restriction, value interface{}) (bool, error) {
+	if len(restriction.Data) == 0 || len(restriction.Data) > 3 {
+		return false, fmt.Errorf("time window restriction needs 1 to 3 values: [days, hours, location]")
+	}
+
+	data := make([]string, 3)
+	for i := 0; i < len(restriction.Data); i++ {
+		data[i] = strings.TrimSpace(fmt.Sprint(restriction.Data[i]))
+	}
+
+	location := time.UTC
+	if data[2] != "" {
+		var err error
+		if location, err = time.LoadLocation(data[2]); err != nil {
+			return false, err
+		}
+	}
+
+	var now time.Time
+	switch v := value.(type) {
+	case nil:
+		now = time.Now()
+	case time.Time:
+		now = v
+	case string:
+		var err error
+		if now, err = time.Parse(time.RFC3339, v); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("'%v' is not a time", value)
+	}
+	now = now.In(location)
+
+	if data[0] != "" && data[0] != "*" {
+		days, err := parseWeekdays(data[0])
+		if err != nil {
+			return false, err
+		}
+		if !days[now.Weekday()] {
+			return false, nil
+		}
+	}
+
+	if data[1] != "" && data[1] != "*" {
+		bounds := strings.Split(data[1], "-")
+		if len(bounds) != 2 {
+			return false, fmt.Errorf("wrong time of day window: '%v'", data[1])
+		}
+		from, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return false, err
+		}
+		to, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return false, err
+		}
+
+		current := now.Hour()*60 + now.Minute()
+		if from <= to {
+			return current >= from && current < to, nil
+		}
+		// overnight window
+		return current >= from || current < to, nil
+	}
+
+	return true, nil
+}
+
+// parseWeekdays parses days lists and ranges, i.e. "mon-fri", "sat,sun", "mon,wed-fri"
+func parseWeekdays(str string) (map[time.Weekday]bool, error) {
+	ret := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(strings.ToLower(str), ",") {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("wrong days: '%v'", part)
+		}
+
+		from, ok := weekdays[strings.TrimSpace(bounds[0])]
+		if !ok {
+			return nil, fmt.Errorf("unknown day: '%v'", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.TrimSpace(bounds[1])]; !ok {
+				return nil, fmt.Errorf("unknown day: '%v'", bounds[1])
+			}
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			ret[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// parseTimeOfDay parses "15:04" into minutes since midnight
+func parseTimeOfDay(str string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(str))
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+const (
+	RestrictionOperatorAnd = "and"
+	RestrictionOperatorOr  = "or"
+	RestrictionOperatorNot = "not"
+)
+
+// RestrictionExpr is a restrictions' expression tree. Each node is either a leaf (Restriction) or a boolean
+// combinator (Operator: and / or / not) over its Children.
+type RestrictionExpr struct {
+	// empty for leaves
+	Operator    string
+	Restriction *Restriction
+	Children    []RestrictionExpr
+	// GeneralRestrictions' leaf: unknown concepts and include without Data are met (backward compatibility)
+	legacy bool
+}
+
+// RestrictionResult is the result of evaluating a RestrictionExpr
+type RestrictionResult struct {
+	OK bool
+	// branch that failed, i.e.: and[1] > or
+	FailedBranch string
+	// IDs of the restrictions that made the expression fail
+	FailedRestrictions []string
+	Message            string
+}
+
+// RestrictionLeaf returns an expression having a single restriction
+func RestrictionLeaf(restriction Restriction) RestrictionExpr {
+	return RestrictionExpr{
+		Restriction: &restriction,
+	}
+}
+
+// legacyRestrictionLeaf returns a leaf for a GeneralRestrictions' restriction
+func legacyRestrictionLeaf(restriction Restriction) RestrictionExpr {
+	ret := RestrictionLeaf(restriction)
+	ret.legacy = true
+
+	return ret
+}
+
+// RestrictionAnd returns an expression met only if all children are met
+func RestrictionAnd(children ...RestrictionExpr) RestrictionExpr {
+	return RestrictionExpr{
+		Operator: RestrictionOperatorAnd,
+		Children: children,
+	}
+}
+
+// RestrictionOr returns an expression met if any child is met
+func RestrictionOr(children ...RestrictionExpr) RestrictionExpr {
+	return RestrictionExpr{
+		Operator: RestrictionOperatorOr,
+		Children: children,
+	}
+}
+
+// RestrictionNot returns an expression met only if the child is not met
+func RestrictionNot(child RestrictionExpr) RestrictionExpr {
+	return RestrictionExpr{
+		Operator: RestrictionOperatorNot,
+		Children: []RestrictionExpr{child},
+	}
+}
+
+// Evaluate evaluates the expression against the given metadata (each restriction uses its Source)
+func (st RestrictionExpr) Evaluate(inputMetadata botio.Metadata, generalMetadata botio.Metadata) RestrictionResult {
+	switch st.Operator {
+	case "":
+		if st.Restriction == nil {
+			return RestrictionResult{OK: true}
+		}
+
+		if isOK, message := evaluateRestriction(*st.Restriction, st.legacy, inputMetadata, generalMetadata); !isOK {
+			return RestrictionResult{
+				FailedBranch:       fmt.Sprintf("restriction '%v'", st.Restriction.ID),
+				FailedRestrictions: []string{st.Restriction.ID},
+				Message:            message,
+			}
+		}
+
+		return RestrictionResult{OK: true}
+
+	case RestrictionOperatorAnd:
+		// the first failing child makes the expression fail
+		for i := 0; i < len(st.Children); i++ {
+			if result := st.Children[i].Evaluate(inputMetadata, generalMetadata); !result.OK {
+				result.FailedBranch = fmt.Sprintf("%v[%v] > %v", st.Operator, i, result.FailedBranch)
+				return result
+			}
+		}
+
+		return RestrictionResult{OK: true}
+
+	case RestrictionOperatorOr:
+		if len(st.Children) == 0 {
+			return RestrictionResult{OK: true}
+		}
+
+		ret := RestrictionResult{
+			FailedBranch:       st.Operator,
+			FailedRestrictions: make([]string, 0),
+		}
+		messages := make([]string, 0, len(st.Children))
+		for i := 0; i < len(st.Children); i++ {
+			result := st.Children[i].Evaluate(inputMetadata, generalMetadata)
+			if result.OK {
+				return result
+			}
+
+			ret.FailedRestrictions = append(ret.FailedRestrictions, result.FailedRestrictions...)
+			messages = append(messages, result.Message)
+		}
+		ret.Message = strings.Join(messages, "\nOR\n")
+
+		return ret
+
+	case RestrictionOperatorNot:
+		if len(st.Children) != 1 {
+			return RestrictionResult{
+				FailedBranch: st.Operator,
+				Message:      fmt.Sprintf("Operator '%v' needs exactly one child, it has %v.", st.Operator, len(st.Children)),
+			}
+		}
+
+		if result := st.Children[0].Evaluate(inputMetadata, generalMetadata); result.OK {
+			ids := st.Children[0].getRestrictionIDs()
+			return RestrictionResult{
+				FailedBranch:       fmt.Sprintf("%v[0]", st.Operator),
+				FailedRestrictions: ids,
+				Message:            fmt.Sprintf("Restrictions %v must not be met.", ids),
+			}
+		}
+
+		return RestrictionResult{OK: true}
+	}
+
+	return RestrictionResult{
+		FailedBranch: st.Operator,
+		Message:      fmt.Sprintf("Unknown restrictions' operator: '%v'", st.Operator),
+	}
+}
+
+// String returns the expression in a readable form, i.e.: and(channel, or(user, not(weekend)))
+func (st RestrictionExpr) String() string {
+	if st.Operator == "" {
+		if st.Restriction == nil {
+			return ""
+		}
+		return st.Restriction.ID
+	}
+
+	children := make([]string, 0, len(st.Children))
+	for i := 0; i < len(st.Children); i++ {
+		children = append(children, st.Children[i].String())
+	}
+
+	return fmt.Sprintf("%v(%v)", st.Operator, strings.Join(children, ", "))
+}
+
+// getRestrictions returns all restrictions (leaves) in the expression
+func (st RestrictionExpr) getRestrictions() []Restriction {
+	ret := make([]Restriction, 0)
+	if st.Restriction != nil {
+		ret = append(ret, *st.Restriction)
+	}
+
+	for i := 0; i < len(st.Children); i++ {
+		ret = append(ret, st.Children[i].getRestrictions()...)
+	}
+
+	return ret
+}
+
+// getRestrictionIDs returns the IDs of all restrictions in the expression
+func (st RestrictionExpr) getRestrictionIDs() []string {
+	ret := make([]string, 0)
+	if st.Restriction != nil {
+		ret = append(ret, st.Restriction.ID)
+	}
+
+	for i := 0; i < len(st.Children); i++ {
+		ret = append(ret, st.Children[i].getRestrictionIDs()...)
+	}
+
+	return ret
+}
+
+// evaluateRestriction returns true whether the metadata (input or general, depending on the restriction's Source)
+// meets the restriction. Legacy restrictions (GeneralRestrictions) keep the original behavior: unknown concepts and
+// include without Data are met.
+func evaluateRestriction(restriction Restriction, legacy bool, inputMetadata botio.Metadata, generalMetadata botio.Metadata) (bool, string) {
+	concept, ok := getRestrictionConcept(restriction.Concept)
+	if !ok && !legacy {
+		return false, fmt.Sprintf("Unknown concept '%v'. Restriction '%v' cannot be checked.", restriction.Concept, restriction.ID)
+	}
+
+	var (
+		metadata      botio.Metadata
+		metadataLabel string
+	)
+	switch restriction.Source {
+	case "", CMDRestrictionSourceGeneral:
+		metadata = generalMetadata
+		metadataLabel = "Metadata"
+	case CMDRestrictionSourceInput:
+		metadata = inputMetadata
+		metadataLabel = "InputMetadata"
+	default:
+		return false, fmt.Sprintf("Unknown source '%v'. Restriction '%v' cannot be checked.", restriction.Source, restriction.ID)
+	}
+
+	// restrictions without field (i.e. time windows) don't depend on metadata
+	var fieldValue interface{}
+	if restriction.Field != "" {
+		var fieldExists bool
+		fieldValue, fieldExists = metadata[restriction.Field]
+		if !fieldExists {
+			return false, fmt.Sprintf("Missing %v field: '%v'. Restriction '%v' cannot be checked.", strings.ToLower(metadataLabel), restriction.Field, restriction.ID)
+		}
+	}
+
+	if legacy && (!ok || (restriction.Concept == CMDRestrictionConceptInclude && len(restriction.Data) == 0)) {
+		return true, ""
+	}
+
+	met, err := concept(restriction, fieldValue)
+	if err != nil {
+		return false, fmt.Sprintf("Restriction '%v' with concept '%v' cannot be checked: %v", restriction.ID, restriction.Concept, err.Error())
+	}
+	if !met && restriction.Field == "" {
+		return false, fmt.Sprintf("Restriction '%v' with concept '%v' is not met.", restriction.ID, restriction.Concept)
+	}
+	if !met {
+		return false, fmt.Sprintf("%v['%v'] does not meet restriction '%v' with concept '%v'.\nCurrent value: '%v'", metadataLabel, restriction.Field, restriction.ID, restriction.Concept, fieldValue)
+	}
+
+	return true, ""
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+const (
+	// failed branch (RestrictionResult) for commands' RequiredRoles
+	restrictionBranchRequiredRoles = "required roles"
+)
+
+// RoleChecker checks the users' roles (see rbac package)
+type RoleChecker interface {
+	// HasAnyRole returns true whether the user has at least one of the given roles
+	HasAnyRole(userID string, roles []string) (bool, error)
+}
+
+// SetRoleChecker sets the checker for the commands' RequiredRoles. Commands requiring roles can't be executed if
+// there is no checker.
+func (st *CMDManager) SetRoleChecker(checker RoleChecker) {
+	st.roleChecker = checker
+}
+
+// checkRequiredRoles verifies that the user (GeneralMetadataFieldUserID) has any of the CMD's required roles
+func (st *CMDManager) checkRequiredRoles(cmd CMD, generalMetadata botio.Metadata) RestrictionResult {
+	if len(cmd.RequiredRoles) == 0 {
+		return RestrictionResult{OK: true}
+	}
+
+	ret := RestrictionResult{
+		FailedBranch:       restrictionBranchRequiredRoles,
+		FailedRestrictions: make([]string, 0),
+	}
+
+	userID := fmt.Sprint(generalMetadata[botio.GeneralMetadataFieldUserID])
+	if _, ok := generalMetadata[botio.GeneralMetadataFieldUserID]; !ok || userID == "" {
+		ret.Message = fmt.Sprintf("Missing metadata field: '%v'. Required roles %v cannot be checked.", botio.GeneralMetadataFieldUserID, cmd.RequiredRoles)
+		return ret
+	}
+
+	if st.roleChecker == nil {
+		ret.Message = fmt.Sprintf("No role checker. Required roles %v cannot be checked.", cmd.RequiredRoles)
+		return ret
+	}
+
+	hasRole, err := st.roleChecker.HasAnyRole(userID, cmd.RequiredRoles)
+	if err != nil {
+		ret.Message = fmt.Sprintf("Required roles %v cannot be checked for user '%v': %v", cmd.RequiredRoles, userID, err.Error())
+		return ret
+	}
+	if !hasRole {
+		ret.Message = fmt.Sprintf("User '%v' does not have any of the required roles %v.", userID, cmd.RequiredRoles)
+		return ret
+	}
+
+	return RestrictionResult{OK: true}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// max number of suggestions
+	maxSuggestions = 3
+)
+
+// suggestion is a candidate pattern along with its distance to the unknown word
+type suggestion struct {
+	pattern  string
+	distance int
+}
+
+// getSuggestions returns the closest word patterns (edit distance and prefix) to the given word, from the closest to
+// the farthest
+func getSuggestions(cmds []CMD, word string) []string {
+	word = strings.ToLower(word)
+	if word == "" {
+		return nil
+	}
+
+	// max edit distance to consider a pattern as a candidate
+	maxDistance := len(word) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	candidates := make([]suggestion, 0)
+	added := make(map[string]bool)
+	for i := 0; i < len(cmds); i++ {
+		// only word patterns could be suggested
+		if cmds[i].PatternType != CMDTypeWord {
+			continue
+		}
+
+		for _, pattern := range cmds[i].Pattern {
+			if added[pattern] {
+				continue
+			}
+
+			distance := getEditDistance(word, pattern)
+			if distance > maxDistance && !strings.HasPrefix(pattern, word) {
+				continue
+			}
+
+			candidates = append(candidates, suggestion{pattern: pattern, distance: distance})
+			added[pattern] = true
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+
+		return candidates[i].pattern < candidates[j].pattern
+	})
+
+	ret := make([]string, 0, maxSuggestions)
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		ret = append(ret, candidates[i].pattern)
+	}
+
+	return ret
+}
+
+// getEditDistance returns the Levenshtein distance between two strings
+func getEditDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := 0; j <= len(rb); j++ {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+// minInt returns the minimum value
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	botio "github.com/enriquebris/goagent/io"
+)
+
+// SetThrottledHandler sets the handler for the throttled entries (rate limit exceeded)
+func (st *CMDManager) SetThrottledHandler(handler CMDHandlerV2) {
+	st.throttledHandler = handler
+}
+
+// ProcessThrottled runs the throttled handler (if any) for an entry exceeding a rate limit. The extra data
+// (CMDExtraDataThrottledScope, CMDExtraDataThrottledRetryAfter) is passed as the request's Metadata.
+func (st *CMDManager) ProcessThrottled(ctx context.Context, entry botio.InputEntry, outputs []botio.Output, extraData map[string]interface{}) error {
+	if st.throttledHandler == nil {
+		return nil
+	}
+
+	return st.runHandler(st.wrapHandler(st.throttledHandler), CMDRequest{
+		Context:     ctx,
+		Content:     strings.TrimSpace(entry.Query),
+		Metadata:    extraData,
+		HandlerType: CMDHandlerTypeThrottled,
+		Entry:       entry,
+		Outputs:     outputs,
+	})
+}
+
+// GetMatchingCMDID returns the ID of the top level command matching the content (empty if no command matches or
+// if the command has no ID). Only patterns are checked, restrictions and params are not.
+func (st *CMDManager) GetMatchingCMDID(content string) string {
+	content = strings.TrimSpace(content)
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return ""
+	}
+	words := getTokenValues(tokens)
+
+	for _, cmd := range st.getCommands() {
+		if _, match := matchPattern(cmd, content, tokens, words); match {
+			return cmd.ID
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a word from the content
+type token struct {
+	value string
+	// position in the content right after the token
+	end int
+}
+
+// tokenize splits the content into words (shell-like):
+//   - words are separated by any number of blank characters (spaces, tabs, newlines)
+//   - 'single quotes' keep everything literally
+//   - "double quotes" keep everything, except \" and \\ which are escaped
+//   - a backslash outside quotes escapes the next character
+//   - quoted parts are joined to the adjacent characters: --notes="deploy notes" ==> --notes=deploy notes
+//
+// An unterminated quote takes the rest of the content.
+func tokenize(content string) []token {
+	var (
+		ret      = make([]token, 0)
+		current  strings.Builder
+		inToken  bool
+		quote    rune
+		escaping bool
+	)
+
+	for pos, r := range content {
+		switch {
+		case escaping:
+			current.WriteRune(r)
+			escaping = false
+
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				// only \" and \\ are escaped inside double quotes
+				if next, _ := utf8.DecodeRuneInString(content[pos+1:]); next == '"' || next == '\\' {
+					escaping = true
+				} else {
+					current.WriteRune(r)
+				}
+			default:
+				current.WriteRune(r)
+			}
+
+		case r == '\\':
+			inToken = true
+			escaping = true
+
+		case r == '\'' || r == '"':
+			inToken = true
+			quote = r
+
+		case unicode.IsSpace(r):
+			if inToken {
+				ret = append(ret, token{value: current.String(), end: pos})
+				current.Reset()
+				inToken = false
+			}
+
+		default:
+			inToken = true
+			current.WriteRune(r)
+		}
+	}
+
+	if inToken {
+		ret = append(ret, token{value: current.String(), end: len(content)})
+	}
+
+	return ret
+}
+
+// getTokenValues returns the tokens' values
+func getTokenValues(tokens []token) []string {
+	ret := make([]string, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		ret[i] = tokens[i].value
+	}
+
+	return ret
+}
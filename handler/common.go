@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/enriquebris/goagent/cmd"
 	botio "github.com/enriquebris/goagent/io"
@@ -68,11 +69,43 @@ func (st *Common) GetErrorHandler(tags []string) cmd.CMDHandler {
 				outputs,
 			)
 		} else {
-			message.SendDontUnderstandMessage(cmdContent, tags, inputMetadata, outputs)
+			suggestions, _ := metadata[cmd.CMDExtraDataSuggestions].([]string)
+			message.SendDontUnderstandMessageWithSuggestions(cmdContent, suggestions, tags, inputMetadata, outputs)
 		}
 	}
 }
 
+// GetUnknownCMDHandler returns a handler for the entries not matching any command (see CMDManager.SetUnknownCMDHandler)
+func (st *Common) GetUnknownCMDHandler(tags []string) cmd.CMDHandlerV2 {
+	return func(request cmd.CMDRequest) error {
+		suggestions, _ := request.Metadata[cmd.CMDExtraDataSuggestions].([]string)
+		message.SendDontUnderstandMessageWithSuggestions(request.Content, suggestions, tags, request.Entry.InputMetadata, request.Outputs)
+
+		return nil
+	}
+}
+
+// GetThrottledHandler returns a handler for the entries exceeding a rate limit (see Agent.SetThrottledHandler)
+func (st *Common) GetThrottledHandler(tags []string) cmd.CMDHandlerV2 {
+	return func(request cmd.CMDRequest) error {
+		extraMessage := ""
+		if retryAfter, ok := request.Metadata[cmd.CMDExtraDataThrottledRetryAfter].(time.Duration); ok {
+			extraMessage = fmt.Sprintf(" Please try again in %v.", (retryAfter + time.Second - 1).Truncate(time.Second))
+		}
+
+		message.SendMessageToOutput(
+			fmt.Sprintf("Whoa, slow down! Too many requests.%v", extraMessage),
+			request.Entry.InputMetadata,
+			botio.Metadata{
+				"Tags": tags,
+			},
+			request.Outputs,
+		)
+
+		return nil
+	}
+}
+
 // GetParametersIncorrectTypeHandler returns a function to handle incorrect param's types
 func (st *Common) GetParametersIncorrectTypeHandler(tags []string) cmd.CMDHandler {
 	return func(command cmd.CMD, pattern string, cmdContent string, metadata botio.Metadata, handlerType string, inputMetadata botio.Metadata, generalMetadata botio.Metadata, outputs []botio.Output) {
@@ -81,7 +114,7 @@ func (st *Common) GetParametersIncorrectTypeHandler(tags []string) cmd.CMDHandle
 		if paramsData, ok := tmp.([]cmd.CMDParam); ok {
 			extraMessage = ": "
 			for i := 0; i < len(paramsData); i++ {
-				extraMessage += fmt.Sprintf("\nparameter: '%v'\nvalue: %v\nexpected type : %v", paramsData[i].ID, paramsData[i].Value, paramsData[i].Type)
+				extraMessage += fmt.Sprintf("\nparameter: '%v'\nvalue: %v\nexpected type : %v", paramsData[i].ID, paramsData[i].Value, paramsData[i].GetTypeDescription())
 			}
 		} else {
 			st.log.Error("Unexpected data for metadata[CMDExtraDataParametersIncorrectType]")
@@ -106,7 +139,7 @@ func (st *Common) GetParametersMissing(tags []string) cmd.CMDHandler {
 		if paramsData, ok := tmp.([]cmd.CMDParam); ok {
 			extraMessage = ": "
 			for i := 0; i < len(paramsData); i++ {
-				extraMessage += fmt.Sprintf("\nparameter: '%v'\nexpected type : %v", paramsData[i].ID, paramsData[i].Type)
+				extraMessage += fmt.Sprintf("\nparameter: '%v'\nexpected type : %v", paramsData[i].ID, paramsData[i].GetTypeDescription())
 			}
 		} else {
 			st.log.Error("Unexpected data for metadata[CMDExtraDataParametersMissing]")
@@ -150,7 +183,7 @@ func (st *Common) GetParametersExtra(tags []string) cmd.CMDHandler {
 
 func (st *Common) GetRestrictionsHandler(tags []string) cmd.CMDHandler {
 	return func(command cmd.CMD, pattern string, cmdContent string, metadata botio.Metadata, handlerType string, inputMetadata botio.Metadata, generalMetadata botio.Metadata, outputs []botio.Output) {
-		st.log.Error(metadata["message"])
+		st.log.Errorf("%v\nFailed branch: %v", metadata["message"], metadata[cmd.CMDExtraDataRestrictionFailedBranch])
 
 		message.SendMessageToOutput(
 			"Sorry, this action cannot be executed because a restriction",
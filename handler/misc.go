@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"sort"
 
-	"github.com/enriquebris/goagent/message"
 	"github.com/enriquebris/goagent/cmd"
+	"github.com/enriquebris/goagent/message"
 )
 
 // getHelpForCMD returns a help string for the given command
@@ -53,12 +53,12 @@ func getHelpForCMD(command cmd.CMD, tagHelp string) string {
 				optionalTmp = "required"
 			}
 			// type
-			typeTmp = command.SubCommands[i].Params[c].Type
-			if typeTmp == "" {
-				typeTmp = "string"
+			typeTmp = command.SubCommands[i].Params[c].GetTypeDescription()
+			if command.SubCommands[i].Params[c].IsSwitch {
+				typeTmp = "switch"
 			}
 
-			ret += fmt.Sprintf("\n%v\t\t\t %v (%v - %v) ==> %v", spaces4params, command.SubCommands[i].Params[c].ID, typeTmp, optionalTmp, command.SubCommands[i].Params[c].Description)
+			ret += fmt.Sprintf("\n%v\t\t\t %v (%v - %v) ==> %v%v", spaces4params, getParamNames(command.SubCommands[i].Params[c]), typeTmp, optionalTmp, command.SubCommands[i].Params[c].Description, getParamDefault(command.SubCommands[i].Params[c]))
 		}
 	}
 
@@ -89,6 +89,25 @@ func getPattern(patterns []string) string {
 	return ret
 }
 
+// getParamNames returns the param's names (ID and aliases) as --name
+func getParamNames(param cmd.CMDParam) string {
+	ret := fmt.Sprintf("--%v", param.ID)
+	for i := 0; i < len(param.Aliases); i++ {
+		ret += fmt.Sprintf(" / --%v", param.Aliases[i])
+	}
+
+	return ret
+}
+
+// getParamDefault returns the param's default value info
+func getParamDefault(param cmd.CMDParam) string {
+	if param.Default == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" (default: %v)", param.Default)
+}
+
 // removeSliceElement returns the slice after remove the given element
 func removeSliceElement(sl []string, element string) []string {
 	pos := -1
@@ -1,8 +1,10 @@
 package flowdock
 
 import (
-	"github.com/enriquebris/goflowdock"
+	"sync"
+
 	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goflowdock"
 	"github.com/fatih/structs"
 )
 
@@ -13,6 +15,13 @@ const (
 type FlowdockInput struct {
 	streamURL     string
 	streamManager *goflowdock.StreamManager
+	// channel the stream's entries are sent to, nil while not listening
+	channel chan botio.InputEntry
+	// closed by Stop to make Listen return
+	stop chan struct{}
+	// receives the stream's result, nil while the stream is not running
+	streamDone chan error
+	mutex      sync.Mutex
 }
 
 func NewFlowdockInput(authToken string, streamURL string) *FlowdockInput {
@@ -27,8 +36,63 @@ func (st *FlowdockInput) initialize(authToken string, streamURL string) {
 	st.streamURL = streamURL
 }
 
+// Listen sends the flowdock stream's entries to the channel until the stream ends or Stop is invoked. The stream is
+// kept open after Stop and reused by the next Listen, so stopping and listening again doesn't open a second stream.
 func (st *FlowdockInput) Listen(chInputEntry chan botio.InputEntry) error {
-	return st.streamManager.Listen(st.streamURL, func(entry goflowdock.Entry) {
+	st.mutex.Lock()
+	st.channel = chInputEntry
+	stop := make(chan struct{})
+	st.stop = stop
+	if st.streamDone == nil {
+		st.streamDone = make(chan error, 1)
+		go st.listenStream(st.streamDone)
+	}
+	streamDone := st.streamDone
+	st.mutex.Unlock()
+
+	select {
+	case err := <-streamDone:
+		// the stream ended, the next Listen opens a new one
+		st.mutex.Lock()
+		st.streamDone = nil
+		st.channel = nil
+		st.stop = nil
+		st.mutex.Unlock()
+
+		return err
+
+	case <-stop:
+		return nil
+	}
+}
+
+// Stop makes Listen return. Entries received until the next Listen are discarded.
+func (st *FlowdockInput) Stop() error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.stop != nil {
+		close(st.stop)
+		st.stop = nil
+	}
+	st.channel = nil
+
+	return nil
+}
+
+// listenStream listens the flowdock stream, its result is sent to done
+func (st *FlowdockInput) listenStream(done chan error) {
+	done <- st.streamManager.Listen(st.streamURL, func(entry goflowdock.Entry) {
+		st.mutex.Lock()
+		channel := st.channel
+		stop := st.stop
+		st.mutex.Unlock()
+
+		// discard the entries while the input is stopped
+		if channel == nil {
+			return
+		}
+
 		// transform goflowdock.Entry into botio.InputEntry
 		inputEntry := botio.InputEntry{
 			Origin: Origin,
@@ -38,7 +102,10 @@ func (st *FlowdockInput) Listen(chInputEntry chan botio.InputEntry) error {
 			GeneralMetadata: st.buildGeneralMetadata(entry),
 		}
 
-		chInputEntry <- inputEntry
+		select {
+		case channel <- inputEntry:
+		case <-stop:
+		}
 	})
 }
 
@@ -3,8 +3,8 @@ package flowdock
 import (
 	"fmt"
 
-	"github.com/enriquebris/goflowdock"
 	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goflowdock"
 )
 
 type FlowdockOutput struct {
@@ -26,7 +26,17 @@ func (st *FlowdockOutput) initialize(authToken string, organization string, user
 	st.username = username
 }
 
+// GetOrigin returns the origin this output replies to
+func (st *FlowdockOutput) GetOrigin() string {
+	return Origin
+}
+
 func (st *FlowdockOutput) Send(messageType string, message string, inputData botio.Metadata, outputData botio.Metadata) error {
+	// a flow is needed to send the message
+	if flow, ok := botio.MergeMetadata(inputData, outputData)["Flow"]; !ok || flow == nil {
+		return fmt.Errorf("missing Flow to output message: %v", message)
+	}
+
 	switch messageType {
 	case botio.OutputMessageTypeDefault:
 		fmt.Println(st.messageManager.SendMessage(buildMessageData(message, st.username, inputData, outputData)))
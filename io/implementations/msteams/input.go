@@ -1,6 +1,7 @@
 package msteams
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	botio "github.com/enriquebris/goagent/io"
@@ -18,8 +20,10 @@ const (
 	Origin                 = "msteams"
 	maxListenQueueCapacity = 500
 	messagePattern         = "<at>([a-zA-Z]+)</at>(.+)\n"
-	timeoutSeconds         = 5
-	timeoutMessage         = "response could not be delivered in time"
+	// default time to wait for the response
+	timeoutSeconds = 5
+	// default message to reply when the response doesn't arrive in time
+	timeoutMessage = "response could not be delivered in time"
 )
 
 type MSTeamsOutgoingData struct {
@@ -46,6 +50,17 @@ type Input struct {
 	minTLSVersion uint16
 	headers       map[string]string
 	cipherSuites  []uint16
+	// time to wait for the response
+	responseTimeout time.Duration
+	// message to reply when the response doesn't arrive in time
+	timeoutMessage string
+
+	// http server
+	server *http.Server
+	// closed once the input gets stopped
+	stop      chan struct{}
+	isStopped bool
+	mutex     sync.Mutex
 }
 
 func (st *Input) initialize(port string, certFilePath string, keyFilePath string) error {
@@ -53,9 +68,14 @@ func (st *Input) initialize(port string, certFilePath string, keyFilePath string
 	st.minTLSVersion = tls.VersionTLS10
 	// headers
 	st.headers = make(map[string]string)
+	// response timeout
+	st.responseTimeout = timeoutSeconds * time.Second
+	st.timeoutMessage = timeoutMessage
 
 	// channel to send input messages to agent
 	st.dataToListen = make(chan MSTeamsOutgoingData, maxListenQueueCapacity)
+	// channel to stop listening
+	st.stop = make(chan struct{})
 
 	var err error
 	// message regex
@@ -78,6 +98,17 @@ func (st *Input) SetCipherSuites(suites []uint16) {
 	st.cipherSuites = suites
 }
 
+// SetResponseTimeout sets the time to wait for the response. It should be greater than the commands' timeout to let
+// the agent send its own timeout message.
+func (st *Input) SetResponseTimeout(timeout time.Duration) {
+	st.responseTimeout = timeout
+}
+
+// SetTimeoutMessage sets the message to reply when the response doesn't arrive in time
+func (st *Input) SetTimeoutMessage(message string) {
+	st.timeoutMessage = message
+}
+
 func (st *Input) AddHeader(key, value string) {
 	st.headers[key] = value
 }
@@ -118,8 +149,40 @@ func (st *Input) initAPI(port string, certFilePath string, keyFilePath string) {
 	// api/v1/msteams/outgoing
 	http.HandleFunc("/api/v1/msteams/outgoing", st.endpointPOSTOutgoing)
 
+	st.mutex.Lock()
+	if st.isStopped {
+		st.mutex.Unlock()
+		return
+	}
+	st.server = s
+	st.mutex.Unlock()
+
 	// run server
-	log.Fatal(s.ListenAndServeTLS("", ""))
+	if err := s.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// Stop shuts down the http server and makes Listen return. In-flight requests get up to the response timeout to be
+// answered.
+func (st *Input) Stop() error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.isStopped {
+		return nil
+	}
+	st.isStopped = true
+	close(st.stop)
+
+	if st.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), st.responseTimeout)
+	defer cancel()
+
+	return st.server.Shutdown(ctx)
 }
 
 func (st *Input) endpointGETPing(w http.ResponseWriter, req *http.Request) {
@@ -189,13 +252,13 @@ func (st *Input) endpointPOSTOutgoing(w http.ResponseWriter, req *http.Request)
 		// response in time
 		outputJSON(w, http.StatusOK, OutgoingResponse{Type: "message", Text: respMessage})
 
-	case <-time.After(timeoutSeconds * time.Second):
+	case <-time.After(st.responseTimeout):
 		// timeout
 
-		// close response channel
-		close(respChan)
+		// the response channel is not closed: a late response (i.e. the agent's timeout message) would panic while
+		// sending to a closed channel. Late responses stay in the buffer or get discarded by the output once it is full.
 		// output timeout message
-		outputJSON(w, http.StatusOK, OutgoingResponse{Type: "message", Text: timeoutMessage})
+		outputJSON(w, http.StatusOK, OutgoingResponse{Type: "message", Text: st.timeoutMessage})
 	}
 
 }
@@ -227,8 +290,9 @@ func (st *Input) Listen(chInputEntry chan botio.InputEntry) error {
 			}
 
 			chInputEntry <- inputEntry
+
+		case <-st.stop:
+			return nil
 		}
 	}
-
-	return nil
 }
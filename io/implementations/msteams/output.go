@@ -12,6 +12,11 @@ func NewOutput() *Output {
 	return &Output{}
 }
 
+// GetOrigin returns the origin this output replies to
+func (st *Output) GetOrigin() string {
+	return Origin
+}
+
 func (st *Output) Send(messageType string, message string, inputData botio.Metadata, outputData botio.Metadata) error {
 	// get the response
 	respChan, ok := inputData[responseChannelField].(*chan string)
@@ -14,10 +14,22 @@ type Input interface {
 	Listen(chan InputEntry) error
 }
 
+// StoppableInput is an Input that could be stopped. Stop makes Listen return.
+type StoppableInput interface {
+	Input
+	Stop() error
+}
+
 type Output interface {
 	Send(messageType string, message string, inputData Metadata, outputData Metadata) error
 }
 
+// FlushableOutput is an Output that could have pending messages to deliver. Flush delivers them.
+type FlushableOutput interface {
+	Output
+	Flush() error
+}
+
 // InputEntry ==> Entry data from the input
 type InputEntry struct {
 	Origin          string
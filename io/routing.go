@@ -0,0 +1,149 @@
+package io
+
+import (
+	"reflect"
+	"sync"
+)
+
+// OriginOutput is an Output bound to an origin (the same value its Input sets as InputEntry.Origin)
+type OriginOutput interface {
+	Output
+	GetOrigin() string
+}
+
+// RoutingRule selects the outputs a reply for the given entry must be sent to
+type RoutingRule interface {
+	Route(entry InputEntry, outputs []Output) []Output
+}
+
+// RoutingRuleFunc is a function that implements RoutingRule
+type RoutingRuleFunc func(entry InputEntry, outputs []Output) []Output
+
+// Route implements RoutingRule
+func (fn RoutingRuleFunc) Route(entry InputEntry, outputs []Output) []Output {
+	return fn(entry, outputs)
+}
+
+// ***********************************************************************************************
+// **  Router  ***********************************************************************************
+// ***********************************************************************************************
+
+// Router selects the outputs for each entry. The result is the union of the outputs selected by each rule.
+// Rules could be modified while routing.
+type Router struct {
+	rules []RoutingRule
+	mutex sync.RWMutex
+}
+
+func NewRouter(rules ...RoutingRule) *Router {
+	ret := &Router{}
+	ret.initialize(rules)
+
+	return ret
+}
+
+func (st *Router) initialize(rules []RoutingRule) {
+	st.rules = make([]RoutingRule, 0, len(rules))
+	st.rules = append(st.rules, rules...)
+}
+
+// AddRule adds a routing rule
+func (st *Router) AddRule(rule RoutingRule) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.rules = append(st.rules, rule)
+}
+
+// SetRules replaces the routing rules
+func (st *Router) SetRules(rules ...RoutingRule) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.initialize(rules)
+}
+
+// Route returns the outputs the replies for the given entry must be sent to
+func (st *Router) Route(entry InputEntry, outputs []Output) []Output {
+	st.mutex.RLock()
+	rules := st.rules
+	st.mutex.RUnlock()
+
+	ret := make([]Output, 0, len(outputs))
+
+	for i := 0; i < len(rules); i++ {
+		for _, output := range rules[i].Route(entry, outputs) {
+			if !containsOutput(ret, output) {
+				ret = append(ret, output)
+			}
+		}
+	}
+
+	return ret
+}
+
+// containsOutput returns true if the output is already in the slice
+func containsOutput(outputs []Output, output Output) bool {
+	if output == nil || !reflect.TypeOf(output).Comparable() {
+		return false
+	}
+
+	for i := 0; i < len(outputs); i++ {
+		if outputs[i] != nil && reflect.TypeOf(outputs[i]) == reflect.TypeOf(output) && outputs[i] == output {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ***********************************************************************************************
+// **  Rules  ************************************************************************************
+// ***********************************************************************************************
+
+// ReplyToOriginRule selects the outputs whose origin matches the entry's origin.
+// Outputs not implementing OriginOutput are considered to serve any origin.
+func ReplyToOriginRule() RoutingRule {
+	return RoutingRuleFunc(func(entry InputEntry, outputs []Output) []Output {
+		ret := make([]Output, 0)
+		for i := 0; i < len(outputs); i++ {
+			if originOutput, ok := outputs[i].(OriginOutput); ok && originOutput.GetOrigin() != entry.Origin {
+				continue
+			}
+
+			ret = append(ret, outputs[i])
+		}
+
+		return ret
+	})
+}
+
+// BroadcastRule selects all outputs
+func BroadcastRule() RoutingRule {
+	return RoutingRuleFunc(func(entry InputEntry, outputs []Output) []Output {
+		return outputs
+	})
+}
+
+// MirrorRule selects the given output (it doesn't need to be added to the agent), no matter the entry's origin.
+// The given inputMetadata overwrites the entry's input metadata for this output (i.e.: the audit channel).
+func MirrorRule(output Output, inputMetadata Metadata) RoutingRule {
+	mirror := &mirrorOutput{
+		output:        output,
+		inputMetadata: inputMetadata,
+	}
+
+	return RoutingRuleFunc(func(entry InputEntry, outputs []Output) []Output {
+		return []Output{mirror}
+	})
+}
+
+// mirrorOutput is an output that overwrites the input metadata before sending the messages
+type mirrorOutput struct {
+	output        Output
+	inputMetadata Metadata
+}
+
+func (st *mirrorOutput) Send(messageType string, message string, inputData Metadata, outputData Metadata) error {
+	return st.output.Send(messageType, message, MergeMetadata(inputData, st.inputMetadata), outputData)
+}
@@ -1,6 +1,8 @@
 package main
 
 const (
+	agentName = "agent"
+
 	commandHelp = "help"
 
 	tagError = "error"
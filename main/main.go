@@ -16,9 +16,9 @@ func main() {
 	// common handler helper
 	commonHandler := handler.NewCommon(log)
 
-	myAgent := agent.NewAgent(log)
+	myAgent := agent.NewAgent(log, 0)
 	// set the input / output
-	myAgent.SetInput(flowdockIOInput)
+	myAgent.AddInput(flowdock.Origin, flowdockIOInput)
 	myAgent.AddOutput(flowdockIOOutput)
 	// add the main command / skill
 	myAgent.AddCMD(example.GetMainCMD(commonHandler))
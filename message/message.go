@@ -2,12 +2,14 @@ package message
 
 import (
 	"fmt"
+	"strings"
 
 	botio "github.com/enriquebris/goagent/io"
 )
 
 const (
 	dontUnderstandMessage = "Hmmm, I don't understand what do you mean by '%v'"
+	didYouMeanMessage     = "\nDid you mean %v?"
 )
 
 // sendMessageToOutput sends a message to the given outputs
@@ -35,3 +37,26 @@ func SendDontUnderstandMessage(cmdContent string, tags []string, inputMetadata b
 		outputs,
 	)
 }
+
+// SendDontUnderstandMessageWithSuggestions sends a "do not understand" message along with the suggestions to the given
+// outputs
+func SendDontUnderstandMessageWithSuggestions(cmdContent string, suggestions []string, tags []string, inputMetadata botio.Metadata, outputs []botio.Output) {
+	if len(suggestions) == 0 {
+		SendDontUnderstandMessage(cmdContent, tags, inputMetadata, outputs)
+		return
+	}
+
+	quoted := make([]string, 0, len(suggestions))
+	for i := 0; i < len(suggestions); i++ {
+		quoted = append(quoted, fmt.Sprintf("`%v`", suggestions[i]))
+	}
+
+	SendMessageToOutput(
+		fmt.Sprintf(dontUnderstandMessage, cmdContent)+fmt.Sprintf(didYouMeanMessage, strings.Join(quoted, " or ")),
+		inputMetadata,
+		botio.Metadata{
+			"Tags": tags,
+		},
+		outputs,
+	)
+}
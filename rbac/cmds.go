@@ -0,0 +1,179 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enriquebris/goagent/cmd"
+	botio "github.com/enriquebris/goagent/io"
+	"github.com/enriquebris/goagent/message"
+)
+
+const (
+	paramRole  = "role"
+	paramUser  = "user"
+	paramGroup = "group"
+)
+
+// GetAdminCMD returns the command to manage the roles from chat. Only users having any of the adminRoles can execute
+// it (at least one admin role is needed, otherwise anyone could grant themselves any role):
+//
+//	<pattern> grant <role> <user>	/ <pattern> grant <role> --group=<group>
+//	<pattern> revoke <role> <user>	/ <pattern> revoke <role> --group=<group>
+//	<pattern> list [user]
+func (st *RBAC) GetAdminCMD(pattern string, adminRoles []string) (cmd.CMD, error) {
+	if len(adminRoles) == 0 {
+		return cmd.CMD{}, fmt.Errorf("the admin command needs at least one admin role")
+	}
+
+	bindingParams := []cmd.CMDParam{
+		{ID: paramRole, Description: "role", Type: cmd.CMDParamTypeString, Required: true},
+		{ID: paramUser, Description: "user ID", Type: cmd.CMDParamTypeString},
+		{ID: paramGroup, Description: "group", Type: cmd.CMDParamTypeString},
+	}
+
+	usageHandler := st.getUsageHandler(pattern)
+
+	return cmd.CMD{
+		PatternType:   cmd.CMDTypeWord,
+		Pattern:       []string{pattern},
+		Description:   "Roles management",
+		RequiredRoles: adminRoles,
+		HandlerV2:     usageHandler,
+		HandlerRestrictionsV2: func(request cmd.CMDRequest) error {
+			reply(request, "Sorry, you are not allowed to manage roles.")
+			return nil
+		},
+		SubCommands: []cmd.CMD{
+			{
+				PatternType:              cmd.CMDTypeWord,
+				Pattern:                  []string{"grant"},
+				Description:              "Grants a role to a user or group",
+				Params:                   bindingParams,
+				HandlerV2:                usageHandler,
+				HandlerParamsV2:          st.getGrantHandler(),
+				HandlerParamsMissingV2:   usageHandler,
+				HandlerParamsWrongTypeV2: usageHandler,
+				HandlerParamsExtraV2:     usageHandler,
+			},
+			{
+				PatternType:              cmd.CMDTypeWord,
+				Pattern:                  []string{"revoke"},
+				Description:              "Revokes a role from a user or group",
+				Params:                   bindingParams,
+				HandlerV2:                usageHandler,
+				HandlerParamsV2:          st.getRevokeHandler(),
+				HandlerParamsMissingV2:   usageHandler,
+				HandlerParamsWrongTypeV2: usageHandler,
+				HandlerParamsExtraV2:     usageHandler,
+			},
+			{
+				PatternType: cmd.CMDTypeWord,
+				Pattern:     []string{"list"},
+				Description: "Lists the user's roles (sender by default)",
+				Params: []cmd.CMDParam{
+					{ID: paramUser, Description: "user ID", Type: cmd.CMDParamTypeString},
+				},
+				HandlerV2:            st.getListHandler(),
+				HandlerParamsV2:      st.getListHandler(),
+				HandlerParamsExtraV2: usageHandler,
+			},
+		},
+	}, nil
+}
+
+// getGrantHandler returns the handler to grant a role
+func (st *RBAC) getGrantHandler() cmd.CMDHandlerV2 {
+	return st.getBindingHandler("granted to", st.store.GrantUserRole, st.store.GrantGroupRole)
+}
+
+// getRevokeHandler returns the handler to revoke a role
+func (st *RBAC) getRevokeHandler() cmd.CMDHandlerV2 {
+	return st.getBindingHandler("revoked from", st.store.RevokeUserRole, st.store.RevokeGroupRole)
+}
+
+// getBindingHandler returns a handler that modifies a user or group binding
+func (st *RBAC) getBindingHandler(action string, userFunc func(string, string) error, groupFunc func(string, string) error) cmd.CMDHandlerV2 {
+	return func(request cmd.CMDRequest) error {
+		role := getParamValue(request, paramRole)
+		userID := getParamValue(request, paramUser)
+		group := getParamValue(request, paramGroup)
+
+		var (
+			err    error
+			target string
+		)
+		switch {
+		case userID != "" && group != "":
+			reply(request, "Please provide a user or a group, not both.")
+			return nil
+		case userID != "":
+			err = userFunc(userID, role)
+			target = fmt.Sprintf("user '%v'", userID)
+		case group != "":
+			err = groupFunc(group, role)
+			target = fmt.Sprintf("group '%v'", group)
+		default:
+			reply(request, "Please provide a user or a group.")
+			return nil
+		}
+
+		if err != nil {
+			reply(request, fmt.Sprintf("Sorry, role '%v' could not be %v %v.", role, action, target))
+			return err
+		}
+
+		reply(request, fmt.Sprintf("Role '%v' %v %v.", role, action, target))
+		return nil
+	}
+}
+
+// getListHandler returns the handler to list the user's roles
+func (st *RBAC) getListHandler() cmd.CMDHandlerV2 {
+	return func(request cmd.CMDRequest) error {
+		userID := getParamValue(request, paramUser)
+		if userID == "" {
+			userID = fmt.Sprint(request.Entry.GeneralMetadata[botio.GeneralMetadataFieldUserID])
+		}
+
+		roles, err := st.GetRoles(userID)
+		if err != nil {
+			reply(request, fmt.Sprintf("Sorry, the roles for user '%v' could not be listed.", userID))
+			return err
+		}
+
+		if len(roles) == 0 {
+			reply(request, fmt.Sprintf("User '%v' has no roles.", userID))
+			return nil
+		}
+
+		reply(request, fmt.Sprintf("User '%v' roles: %v", userID, strings.Join(roles, ", ")))
+		return nil
+	}
+}
+
+// getUsageHandler returns a handler replying the admin command's usage
+func (st *RBAC) getUsageHandler(pattern string) cmd.CMDHandlerV2 {
+	return func(request cmd.CMDRequest) error {
+		reply(request, fmt.Sprintf(
+			"Usage:\n\t%[1]v grant <role> <user> | %[1]v grant <role> --group=<group>\n\t%[1]v revoke <role> <user> | %[1]v revoke <role> --group=<group>\n\t%[1]v list [user]",
+			pattern,
+		))
+		return nil
+	}
+}
+
+// getParamValue returns the bound value of the given param (empty if not provided)
+func getParamValue(request cmd.CMDRequest, id string) string {
+	param, err := request.GetParam(id)
+	if err != nil {
+		return ""
+	}
+
+	return param.Value
+}
+
+// reply sends the message to the request's outputs
+func reply(request cmd.CMDRequest, text string) {
+	message.SendMessageToOutput(text, request.Entry.InputMetadata, botio.Metadata{}, request.Outputs)
+}
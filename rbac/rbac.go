@@ -0,0 +1,79 @@
+package rbac
+
+import (
+	"sort"
+)
+
+// RBAC resolves the users' roles (own roles plus their groups' roles) from a Store. It implements cmd.RoleChecker.
+type RBAC struct {
+	store Store
+}
+
+func NewRBAC(store Store) *RBAC {
+	ret := &RBAC{}
+	ret.initialize(store)
+
+	return ret
+}
+
+func (st *RBAC) initialize(store Store) {
+	st.store = store
+}
+
+// GetStore returns the bindings' store
+func (st *RBAC) GetStore() Store {
+	return st.store
+}
+
+// GetRoles returns all the user's roles: the ones bound to the user and the ones bound to the user's groups
+func (st *RBAC) GetRoles(userID string) ([]string, error) {
+	roles := make(map[string]bool)
+
+	userRoles, err := st.store.GetUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range userRoles {
+		roles[role] = true
+	}
+
+	groups, err := st.store.GetUserGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		groupRoles, err := st.store.GetGroupRoles(group)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range groupRoles {
+			roles[role] = true
+		}
+	}
+
+	ret := make([]string, 0, len(roles))
+	for role := range roles {
+		ret = append(ret, role)
+	}
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// HasAnyRole returns true whether the user has at least one of the given roles
+func (st *RBAC) HasAnyRole(userID string, roles []string) (bool, error) {
+	userRoles, err := st.GetRoles(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, userRole := range userRoles {
+		for _, role := range roles {
+			if userRole == role {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
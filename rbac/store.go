@@ -0,0 +1,132 @@
+package rbac
+
+import (
+	"sort"
+	"sync"
+)
+
+// Store keeps the roles' bindings: user-to-role, group-to-role and user-to-group
+type Store interface {
+	// GetUserRoles returns the roles bound to the user (group roles not included)
+	GetUserRoles(userID string) ([]string, error)
+	// GetUserGroups returns the groups the user belongs to
+	GetUserGroups(userID string) ([]string, error)
+	// GetGroupRoles returns the roles bound to the group
+	GetGroupRoles(group string) ([]string, error)
+	GrantUserRole(userID string, role string) error
+	RevokeUserRole(userID string, role string) error
+	GrantGroupRole(group string, role string) error
+	RevokeGroupRole(group string, role string) error
+}
+
+// MemoryStore is an in-memory Store
+type MemoryStore struct {
+	userRoles  map[string]map[string]bool
+	groupRoles map[string]map[string]bool
+	userGroups map[string]map[string]bool
+	mutex      sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	ret := &MemoryStore{}
+	ret.initialize()
+
+	return ret
+}
+
+func (st *MemoryStore) initialize() {
+	st.userRoles = make(map[string]map[string]bool)
+	st.groupRoles = make(map[string]map[string]bool)
+	st.userGroups = make(map[string]map[string]bool)
+}
+
+// GetUserRoles returns the roles bound to the user
+func (st *MemoryStore) GetUserRoles(userID string) ([]string, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return getSortedKeys(st.userRoles[userID]), nil
+}
+
+// GetUserGroups returns the groups the user belongs to
+func (st *MemoryStore) GetUserGroups(userID string) ([]string, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return getSortedKeys(st.userGroups[userID]), nil
+}
+
+// GetGroupRoles returns the roles bound to the group
+func (st *MemoryStore) GetGroupRoles(group string) ([]string, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return getSortedKeys(st.groupRoles[group]), nil
+}
+
+// GrantUserRole binds the role to the user
+func (st *MemoryStore) GrantUserRole(userID string, role string) error {
+	st.add(st.userRoles, userID, role)
+	return nil
+}
+
+// RevokeUserRole unbinds the role from the user
+func (st *MemoryStore) RevokeUserRole(userID string, role string) error {
+	st.remove(st.userRoles, userID, role)
+	return nil
+}
+
+// GrantGroupRole binds the role to the group
+func (st *MemoryStore) GrantGroupRole(group string, role string) error {
+	st.add(st.groupRoles, group, role)
+	return nil
+}
+
+// RevokeGroupRole unbinds the role from the group
+func (st *MemoryStore) RevokeGroupRole(group string, role string) error {
+	st.remove(st.groupRoles, group, role)
+	return nil
+}
+
+// AddUserToGroup adds the user to the group
+func (st *MemoryStore) AddUserToGroup(userID string, group string) {
+	st.add(st.userGroups, userID, group)
+}
+
+// RemoveUserFromGroup removes the user from the group
+func (st *MemoryStore) RemoveUserFromGroup(userID string, group string) {
+	st.remove(st.userGroups, userID, group)
+}
+
+// add adds the value to the key's set
+func (st *MemoryStore) add(bindings map[string]map[string]bool, key string, value string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if _, ok := bindings[key]; !ok {
+		bindings[key] = make(map[string]bool)
+	}
+	bindings[key][value] = true
+}
+
+// remove removes the value from the key's set
+func (st *MemoryStore) remove(bindings map[string]map[string]bool, key string, value string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	delete(bindings[key], value)
+	if len(bindings[key]) == 0 {
+		delete(bindings, key)
+	}
+}
+
+// getSortedKeys returns the set's values, sorted
+func getSortedKeys(set map[string]bool) []string {
+	ret := make([]string, 0, len(set))
+	for key := range set {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+
+	return ret
+}